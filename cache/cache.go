@@ -0,0 +1,450 @@
+// Package cache maintains a persistent, on-disk, index of the headers
+// of the messages stored within maildir folders.
+//
+// Parsing every message in a large folder is slow, so the first time
+// we see a message we store its headers and flags in a per-maildir
+// cache-file.  Future reads can then return those headers without
+// opening the message at all, as long as the file's modification-time
+// and size are unchanged.
+//
+// Because the flags of a message are stored in its filename cache
+// entries are keyed by the unique part of the name, so changing the
+// flags of a message doesn't require it to be parsed again.
+//
+// We also record the listing of each maildir, along with the
+// modification-times of its new/ and cur/ directories.  If those
+// directories are unchanged we can avoid walking them entirely.
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// version is stored in each cache-file, if the format of the cache changes
+// this should be bumped so that older files are ignored.
+const version = 1
+
+// Cache holds our state.
+type Cache struct {
+
+	// Directory holds the location of our cache-files.
+	Directory string
+}
+
+// Entry holds the cached details of a single message.
+type Entry struct {
+
+	// Path holds the path of the message, relative to the maildir.
+	Path string
+
+	// ModTime holds the modification-time of the message, in
+	// nanoseconds since the epoch.
+	ModTime int64
+
+	// Size holds the size of the message, in bytes.
+	Size int64
+
+	// Flags holds the flags of the message, as of the time it was
+	// cached.
+	Flags string
+
+	// Header holds the parsed headers of the message.
+	Header mail.Header
+}
+
+// record is the structure we serialize to disk.
+type record struct {
+
+	// Version holds the version of our cache-format.
+	Version int
+
+	// Maildir holds the path to the maildir this record describes.
+	Maildir string
+
+	// Listed is true if we have a valid listing.
+	Listed bool
+
+	// NewTime and CurTime hold the modification-times of the
+	// new/ and cur/ directories when the listing was taken.
+	NewTime int64
+	CurTime int64
+
+	// Listing holds the unique-names of the messages in the
+	// maildir, sorted by modification-time.
+	Listing []string
+
+	// Entries holds the cached messages, indexed by unique-name.
+	Entries map[string]*Entry
+}
+
+// Maildir holds the cached state for a single maildir folder.
+//
+// It is safe to use from multiple goroutines.
+type Maildir struct {
+
+	// mutex protects our state.
+	mutex sync.Mutex
+
+	// path holds the path to the maildir.
+	path string
+
+	// file holds the path to our cache-file.
+	file string
+
+	// data holds our cached state.
+	data record
+
+	// seen records the messages we've found in the maildir, so
+	// that we can prune entries for messages which have gone.
+	seen map[string]bool
+
+	// listed is true if we've listed the maildir, which means
+	// that `seen` is complete.
+	listed bool
+
+	// dirty is true if we need to save our state.
+	dirty bool
+}
+
+// DefaultDirectory returns the default location for our cache-files.
+func DefaultDirectory() string {
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(dir, "maildir-tools")
+}
+
+// New creates a new cache, storing files beneath the given directory.
+func New(directory string) *Cache {
+	return &Cache{Directory: directory}
+}
+
+// file returns the name of the cache-file for the given maildir.
+func (c *Cache) file(maildir string) string {
+
+	abs, err := filepath.Abs(maildir)
+	if err == nil {
+		maildir = abs
+	}
+
+	sum := sha1.Sum([]byte(filepath.Clean(maildir)))
+	return filepath.Join(c.Directory, hex.EncodeToString(sum[:])+".gob")
+}
+
+// Open loads the cached state for the given maildir.
+//
+// If there is no cached state, or it is invalid, we return an empty
+// cache which will be populated as messages are read.
+func (c *Cache) Open(maildir string) *Maildir {
+
+	m := &Maildir{path: maildir, file: c.file(maildir), seen: make(map[string]bool)}
+
+	// Load the existing state, if we can.
+	fh, err := os.Open(m.file)
+	if err == nil {
+		err = gob.NewDecoder(fh).Decode(&m.data)
+		fh.Close()
+	}
+
+	// Discard things that are invalid or outdated.
+	if err != nil || m.data.Version != version || m.data.Entries == nil {
+		m.data = record{Version: version, Maildir: maildir, Entries: make(map[string]*Entry)}
+	}
+
+	return m
+}
+
+// Clear removes the cached state for the given maildirs, or all maildirs
+// if none are specified.
+func (c *Cache) Clear(maildirs ...string) error {
+
+	var files []string
+
+	if len(maildirs) == 0 {
+		var err error
+		files, err = filepath.Glob(filepath.Join(c.Directory, "*.gob"))
+		if err != nil {
+			return err
+		}
+	}
+	for _, maildir := range maildirs {
+		files = append(files, c.file(maildir))
+	}
+
+	for _, file := range files {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dirTime returns the modification-time of the given directory.
+func dirTime(path string) int64 {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.ModTime().UnixNano()
+}
+
+// Files returns the message-files in the maildir, sorted by
+// modification-time.
+//
+// If the new/ and cur/ directories haven't changed since we last listed
+// the maildir we return the cached listing, otherwise we use the finder
+// to walk the maildir and record the result.
+func (m *Maildir) Files(f *finder.Finder) []finder.MessageFile {
+
+	newTime := dirTime(filepath.Join(m.path, "new"))
+	curTime := dirTime(filepath.Join(m.path, "cur"))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Can we use our cached listing?
+	if m.data.Listed && m.data.NewTime == newTime && m.data.CurTime == curTime {
+
+		files := make([]finder.MessageFile, 0, len(m.data.Listing))
+		for _, name := range m.data.Listing {
+			ent, ok := m.data.Entries[name]
+			if !ok {
+				break
+			}
+			m.seen[name] = true
+			files = append(files, finder.MessageFile{
+				Path:    filepath.Join(m.path, ent.Path),
+				ModTime: time.Unix(0, ent.ModTime),
+				Size:    ent.Size,
+			})
+		}
+
+		// If the listing was complete we're done.
+		if len(files) == len(m.data.Listing) {
+			m.listed = true
+			return files
+		}
+	}
+
+	// Walk the maildir.
+	files := f.MessageFiles(m.path)
+
+	listing := make([]string, len(files))
+	for i, file := range files {
+
+		name := finder.Unique(file.Path)
+		m.seen[name] = true
+		listing[i] = name
+
+		// Update, or create, the entry for this message.
+		ent, ok := m.data.Entries[name]
+		if !ok || ent.ModTime != file.ModTime.UnixNano() || ent.Size != file.Size {
+			ent = &Entry{ModTime: file.ModTime.UnixNano(), Size: file.Size}
+			m.data.Entries[name] = ent
+			m.dirty = true
+		}
+
+		path, _ := filepath.Rel(m.path, file.Path)
+		if ent.Path != path {
+			ent.Path = path
+			ent.Flags = (&mailreader.Email{Filename: file.Path}).Flags()
+			m.dirty = true
+		}
+	}
+
+	//
+	// If a directory was modified very recently then it might be
+	// modified again without the timestamp changing, on filesystems
+	// with a coarse resolution.  Only trust the listing if the
+	// directories have been stable for a while.
+	//
+	stable := time.Now().Add(-2 * time.Second).UnixNano()
+	listed := newTime < stable && curTime < stable
+
+	if listed != m.data.Listed || newTime != m.data.NewTime || curTime != m.data.CurTime || len(listing) != len(m.data.Listing) {
+		m.dirty = true
+	}
+	for i := 0; !m.dirty && i < len(listing); i++ {
+		if listing[i] != m.data.Listing[i] {
+			m.dirty = true
+		}
+	}
+
+	m.data.Listed = listed
+	m.data.NewTime = newTime
+	m.data.CurTime = curTime
+	m.data.Listing = listing
+	m.listed = true
+
+	return files
+}
+
+// Email returns a mail-reading object for the given message-file.
+//
+// If the message is present in our cache, and its modification-time
+// and size are unchanged, the cached headers are used.  Otherwise the
+// message is parsed, and the result cached.
+func (m *Maildir) Email(file finder.MessageFile) (*mailreader.Email, error) {
+
+	name := finder.Unique(file.Path)
+	rel, _ := filepath.Rel(m.path, file.Path)
+
+	m.mutex.Lock()
+	m.seen[name] = true
+	ent, ok := m.data.Entries[name]
+	if ok && ent.Header != nil && ent.ModTime == file.ModTime.UnixNano() && ent.Size == file.Size {
+		header := ent.Header
+
+		// The flags might have changed.
+		if ent.Path != rel {
+			ent.Path = rel
+			ent.Flags = (&mailreader.Email{Filename: file.Path}).Flags()
+			m.dirty = true
+		}
+		m.mutex.Unlock()
+		return mailreader.NewFromHeader(file.Path, header), nil
+	}
+	m.mutex.Unlock()
+
+	// Parse the message, without holding our lock.
+	mail, err := mailreader.New(file.Path)
+	if err != nil {
+		return mail, err
+	}
+
+	m.mutex.Lock()
+	m.data.Entries[name] = &Entry{
+		Path:    rel,
+		ModTime: file.ModTime.UnixNano(),
+		Size:    file.Size,
+		Flags:   mail.Flags(),
+		Header:  mail.Message.Header,
+	}
+	m.dirty = true
+	m.mutex.Unlock()
+
+	return mail, nil
+}
+
+// Entries returns the number of messages in the cache, and the number
+// of those which have cached headers.
+func (m *Maildir) Entries() (int, int) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	headers := 0
+	for _, ent := range m.data.Entries {
+		if ent.Header != nil {
+			headers++
+		}
+	}
+	return len(m.data.Entries), headers
+}
+
+// Verify compares the cache against the messages on-disk, returning a
+// description of each problem found.
+//
+// Cache entries which are missing, or which refer to files which have
+// been changed, are reported.
+func (m *Maildir) Verify(f *finder.Finder) []string {
+
+	var problems []string
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// The messages which are present.
+	present := make(map[string]bool)
+
+	for _, file := range f.MessageFiles(m.path) {
+		name := finder.Unique(file.Path)
+		present[name] = true
+
+		ent, ok := m.data.Entries[name]
+		if !ok || ent.Header == nil {
+			problems = append(problems, fmt.Sprintf("%s is not cached", file.Path))
+			continue
+		}
+		if ent.ModTime != file.ModTime.UnixNano() || ent.Size != file.Size {
+			problems = append(problems, fmt.Sprintf("%s has changed", file.Path))
+		}
+	}
+
+	for name, ent := range m.data.Entries {
+		if !present[name] {
+			problems = append(problems, fmt.Sprintf("%s has been removed", filepath.Join(m.path, ent.Path)))
+		}
+	}
+
+	return problems
+}
+
+// Save writes the cache to disk, if it has changed.
+//
+// Entries for messages we haven't seen are removed, as long as the
+// maildir has been listed.
+func (m *Maildir) Save() error {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Prune messages which are no longer present.
+	if m.listed {
+		for name := range m.data.Entries {
+			if !m.seen[name] {
+				delete(m.data.Entries, name)
+				m.dirty = true
+			}
+		}
+	}
+
+	if !m.dirty {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(m.file), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file, then rename into place.
+	tmp, err := ioutil.TempFile(filepath.Dir(m.file), ".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = gob.NewEncoder(tmp).Encode(&m.data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	m.dirty = false
+	return nil
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skx/maildir-tools/finder"
+)
+
+// makeMaildir creates a temporary maildir, containing a single message.
+func makeMaildir(t *testing.T) (string, string) {
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		os.MkdirAll(filepath.Join(dir, "maildir", sub), 0755)
+	}
+
+	path := filepath.Join(dir, "maildir", "cur", "1234.host:2,S")
+	err = ioutil.WriteFile(path, []byte("Subject: cached\n\nBody\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	return dir, path
+}
+
+func TestCache(t *testing.T) {
+
+	dir, path := makeMaildir(t)
+	defer os.RemoveAll(dir)
+
+	maildir := filepath.Join(dir, "maildir")
+	find := finder.New(dir)
+	c := New(filepath.Join(dir, "cache"))
+
+	// Populate the cache.
+	index := c.Open(maildir)
+	files := index.Files(find)
+	if len(files) != 1 {
+		t.Fatalf("expected one message, got %d", len(files))
+	}
+	mail, err := index.Email(files[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if mail.Header("Subject") != "cached" {
+		t.Fatalf("unexpected subject: %s", mail.Header("Subject"))
+	}
+	if err = index.Save(); err != nil {
+		t.Fatalf("failed to save cache: %s", err)
+	}
+
+	// Change the message, without changing the size or mtime.
+	info, _ := os.Stat(path)
+	ioutil.WriteFile(path, []byte("Subject: change\n\nBody\n"), 0644)
+	os.Chtimes(path, time.Now(), info.ModTime())
+
+	// We should get the cached result.
+	index = c.Open(maildir)
+	if total, headers := index.Entries(); total != 1 || headers != 1 {
+		t.Fatalf("unexpected cache-size %d/%d", total, headers)
+	}
+	mail, err = index.Email(find.MessageFiles(maildir)[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if mail.Header("Subject") != "cached" {
+		t.Fatalf("expected cached subject, got: %s", mail.Header("Subject"))
+	}
+
+	// Now change the mtime; the message should be parsed again.
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Hour))
+	if len(index.Verify(find)) != 1 {
+		t.Fatalf("expected verification to fail")
+	}
+	mail, err = index.Email(find.MessageFiles(maildir)[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if mail.Header("Subject") != "change" {
+		t.Fatalf("expected updated subject, got: %s", mail.Header("Subject"))
+	}
+
+	// Clearing the cache should remove everything.
+	if err = c.Clear(); err != nil {
+		t.Fatalf("failed to clear cache: %s", err)
+	}
+	if total, _ := c.Open(maildir).Entries(); total != 0 {
+		t.Fatalf("cache wasn't cleared")
+	}
+}
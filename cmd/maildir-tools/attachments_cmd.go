@@ -0,0 +1,212 @@
+// List, or extract, the attachments of messages.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/formatter"
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// attachmentsCmd holds our state
+type attachmentsCmd struct {
+
+	// The format-string to use for listing attachments
+	format string
+
+	// The directory to extract attachments into.
+	extract string
+
+	// The index of the single attachment to extract.
+	index int
+}
+
+//
+// Glue
+//
+func (*attachmentsCmd) Name() string     { return "attachments" }
+func (*attachmentsCmd) Synopsis() string { return "List, or extract, the attachments of messages." }
+func (*attachmentsCmd) Usage() string {
+	return `attachments [-extract DIR] [-index N] file1 .. fileN :
+  List the attachments, and inline-parts, of the given message-files.
+
+  If '-extract DIR' is specified then all attachments will be written
+ to the given directory.  If '-index N' is specified then only the Nth
+ attachment of each message will be written, to the current directory
+ unless '-extract' is used too.
+
+  Filenames are taken from the messages, but are sanitized such that
+ attachments can never be written outside the target directory.  Existing
+ files will never be overwritten.
+`
+}
+
+//
+// Flag setup
+//
+func (p *attachmentsCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.format, "format", "#{3index} #{disposition} [#{type}, #{size} bytes] #{filename}", "Specify the format-string to use for listing attachments.")
+	f.StringVar(&p.extract, "extract", "", "Extract attachments into the given directory.")
+	f.IntVar(&p.index, "index", 0, "Only extract the attachment with the given index.")
+}
+
+// safeFilename returns a filename, derived from the one supplied in a
+// message, which is safe to write beneath a directory.
+//
+// Directory components are removed, as are control-characters and any
+// leading periods.  If nothing remains a default name is generated
+// from the index of the attachment.
+func safeFilename(name string, index int) string {
+
+	// Both kinds of path-separator could be used for traversal.
+	name = strings.Replace(name, "\\", "/", -1)
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	// Remove control-characters.
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	// No hidden files, and no "." or "..".
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+
+	if name == "" {
+		name = fmt.Sprintf("attachment-%d", index)
+	}
+	return name
+}
+
+// save writes the given content to a file beneath the specified
+// directory, never overwriting an existing file.
+//
+// The path of the file that was written is returned.
+func save(dir string, name string, content []byte) (string, error) {
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; ; i++ {
+
+		path := filepath.Join(dir, name)
+		if i > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_, err = f.Write(content)
+		if err == nil {
+			err = f.Close()
+		} else {
+			f.Close()
+		}
+		return path, err
+	}
+}
+
+// process lists, or extracts, the attachments of a single message.
+func (p *attachmentsCmd) process(path string) error {
+
+	mail, err := mailreader.NewEnmime(path)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := mail.Attachments()
+	if err != nil {
+		return err
+	}
+
+	extract := p.extract != "" || p.index > 0
+	tmpl := formatter.Compile(p.format)
+
+	dir := p.extract
+	if dir == "" {
+		dir = "."
+	}
+
+	for i, att := range attachments {
+
+		index := i + 1
+
+		if extract {
+
+			if p.index > 0 && p.index != index {
+				continue
+			}
+
+			out, err := save(dir, safeFilename(att.Filename, index), att.Content)
+			if err != nil {
+				return err
+			}
+			fmt.Println(out)
+			continue
+		}
+
+		mapper := func(field string) string {
+			switch field {
+			case "content_id":
+				return att.ContentID
+			case "disposition":
+				if att.Inline {
+					return "inline"
+				}
+				return "attachment"
+			case "file":
+				return path
+			case "filename":
+				return att.Filename
+			case "index":
+				return fmt.Sprintf("%d", index)
+			case "size":
+				return fmt.Sprintf("%d", att.Size)
+			case "type":
+				return att.ContentType
+			}
+			return "Unknown variable " + field
+		}
+
+		fmt.Println(tmpl.Render(mapper))
+	}
+
+	if p.index > len(attachments) {
+		return fmt.Errorf("%s has no attachment with index %d", path, p.index)
+	}
+
+	return nil
+}
+
+//
+// Entry-point.
+//
+func (p *attachmentsCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	ret := subcommands.ExitSuccess
+
+	for _, path := range f.Args() {
+		err := p.process(path)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			ret = subcommands.ExitFailure
+		}
+	}
+
+	return ret
+}
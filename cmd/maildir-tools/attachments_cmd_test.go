@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeFilename(t *testing.T) {
+
+	type TestCase struct {
+		Name   string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{"/etc/passwd", "passwd"},
+		{"..", "attachment-3"},
+		{".", "attachment-3"},
+		{"", "attachment-3"},
+		{"   ", "attachment-3"},
+		{"dir/", "attachment-3"},
+		{".bashrc", "bashrc"},
+		{"..\\..\\windows\\win.ini", "win.ini"},
+		{"C:\\temp\\file.txt", "file.txt"},
+		{"evil\x00.txt", "evil.txt"},
+		{"new\nline.txt", "newline.txt"},
+		{"\x00", "attachment-3"},
+		{"日本語.txt", "日本語.txt"},
+	}
+
+	for _, tst := range tests {
+		out := safeFilename(tst.Name, 3)
+		if out != tst.Output {
+			t.Errorf("safeFilename(%q): expected %q, got %q", tst.Name, tst.Output, out)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Saving the same name repeatedly never overwrites.
+	expected := []string{"file.txt", "file-1.txt", "file-2.txt"}
+	for i, name := range expected {
+
+		path, err := save(dir, "file.txt", []byte{byte('a' + i)})
+		if err != nil {
+			t.Fatalf("failed to save: %s", err)
+		}
+		if path != filepath.Join(dir, name) {
+			t.Errorf("expected %s, got %s", filepath.Join(dir, name), path)
+		}
+	}
+
+	for i, name := range expected {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+		if string(content) != string(byte('a'+i)) {
+			t.Errorf("%s: unexpected content %q", name, content)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Change the flags of messages.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+var (
+	// flagChange matches arguments such as "+S" or "-FR".
+	flagChange = regexp.MustCompile("^([+-])([A-Za-z]+)$")
+)
+
+// flagCmd holds our state
+type flagCmd struct {
+}
+
+//
+// Glue
+//
+func (*flagCmd) Name() string     { return "flag" }
+func (*flagCmd) Synopsis() string { return "Add or remove flags from messages." }
+func (*flagCmd) Usage() string {
+	return `flag [+FLAGS] [-FLAGS] file1 .. fileN :
+  Add flags to, or remove flags from, the given message-files.
+
+  Flags are stored in the filename of a message, so changing them means
+ renaming the file.  The new path of each message is printed, so that
+ scripts may keep track of it.
+
+  The flags which may be changed are:
+
+    D - Draft.
+    F - Flagged.
+    P - Passed.
+    R - Replied.
+    S - Seen.
+    T - Trashed.
+
+  For example to mark a message as having been read, and remove any
+ flagged-status you'd run:
+
+    maildir-tools flag +S -F ~/Maildir/foo/new/1234.example.org
+
+  If your first argument removes flags you must use '--', to avoid it
+ being treated as a command-line option:
+
+    maildir-tools flag -- -S ~/Maildir/foo/cur/1234.example.org:2,S
+`
+}
+
+//
+// Flag setup
+//
+func (p *flagCmd) SetFlags(f *flag.FlagSet) {
+}
+
+//
+// Entry-point.
+//
+func (p *flagCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	add := ""
+	remove := ""
+
+	//
+	// Leading arguments are flag-changes, the rest are files.
+	//
+	args := f.Args()
+	for len(args) > 0 {
+		m := flagChange.FindStringSubmatch(args[0])
+		if len(m) == 0 {
+			break
+		}
+		if m[1] == "+" {
+			add += m[2]
+		} else {
+			remove += m[2]
+		}
+		args = args[1:]
+	}
+
+	if add == "" && remove == "" {
+		fmt.Printf("No flags to add or remove were specified.\n")
+		return subcommands.ExitUsageError
+	}
+
+	ret := subcommands.ExitSuccess
+
+	for _, path := range args {
+
+		out, err := mailreader.ChangeFlags(path, add, remove)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			ret = subcommands.ExitFailure
+			continue
+		}
+		fmt.Println(out)
+	}
+
+	return ret
+}
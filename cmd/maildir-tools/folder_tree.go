@@ -0,0 +1,171 @@
+// The tree of maildir folders shown by the ui, and the persistence of
+// which parts of it are expanded.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// folderNode is a single folder in a tree of maildirs.
+type folderNode struct {
+
+	// key identifies the folder, via its logical name.
+	key string
+
+	// name holds the last component of the logical name.
+	name string
+
+	// path holds the path to the maildir, which is empty for parents
+	// which exist only because they have children.
+	path string
+
+	// depth holds the depth of the folder in the tree.
+	depth int
+
+	// unread and total hold the counts of messages in this folder,
+	// excluding its children.
+	unread int
+	total  int
+
+	// children holds the subfolders of this folder.
+	children []*folderNode
+}
+
+// buildFolderTree arranges the given maildirs into a tree, via their
+// logical names, returning the top-level folders.
+//
+// Parents which don't exist are created, so that ".Lists.golang" will
+// appear beneath "Lists" even if there is no ".Lists" maildir.
+func buildFolderTree(maildirs []Maildir) []*folderNode {
+
+	var roots []*folderNode
+	nodes := make(map[string]*folderNode)
+
+	for _, maildir := range maildirs {
+
+		var parent *folderNode
+		for i := range maildir.Parts {
+
+			key := strings.Join(maildir.Parts[:i+1], "/")
+
+			node, ok := nodes[key]
+			if !ok {
+				node = &folderNode{key: key, name: maildir.Parts[i], depth: i}
+				nodes[key] = node
+
+				if parent == nil {
+					roots = append(roots, node)
+				} else {
+					parent.children = append(parent.children, node)
+				}
+			}
+			parent = node
+		}
+
+		if parent != nil {
+			parent.path = maildir.Path
+			parent.unread = maildir.Unread
+			parent.total = maildir.Total
+		}
+	}
+
+	return roots
+}
+
+// counts returns the number of unread, and total, messages in this
+// folder and all of its children.
+func (n *folderNode) counts() (int, int) {
+
+	unread := n.unread
+	total := n.total
+	for _, c := range n.children {
+		u, t := c.counts()
+		unread += u
+		total += t
+	}
+	return unread, total
+}
+
+// visibleFolders returns the folders which should be shown, which are
+// the top-level folders and the children of any expanded folders.
+func visibleFolders(roots []*folderNode, expanded map[string]bool) []*folderNode {
+
+	var ret []*folderNode
+	for _, n := range roots {
+		ret = append(ret, n)
+		if expanded[n.key] {
+			ret = append(ret, visibleFolders(n.children, expanded)...)
+		}
+	}
+	return ret
+}
+
+// defaultStateFile returns the file used to record which folders are
+// expanded in the ui.
+func defaultStateFile() string {
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "maildir-tools", "expanded-folders")
+}
+
+// loadExpanded returns the folders recorded as expanded in the given
+// file, which contains one logical name per line.
+//
+// A missing, or unreadable, file just means nothing is expanded.
+func loadExpanded(path string) map[string]bool {
+
+	expanded := make(map[string]bool)
+	if path == "" {
+		return expanded
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return expanded
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			expanded[line] = true
+		}
+	}
+
+	return expanded
+}
+
+// saveExpanded records the expanded folders in the given file.
+func saveExpanded(path string, expanded map[string]bool) error {
+
+	if path == "" {
+		return nil
+	}
+
+	var keys []string
+	for key, ok := range expanded {
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	content := strings.Join(keys, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return ioutil.WriteFile(path, []byte(content), 0644)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/maildir-tools/finder"
+)
+
+// testMaildirs returns some maildirs, with counts, in the order the
+// maildirs sub-command would list them.
+func testMaildirs() []Maildir {
+
+	maildir := func(name string, unread int, total int) Maildir {
+		return Maildir{Path: "/mail/" + name, Name: name,
+			Parts:  strings.Split(name, "/"),
+			Counts: finder.Counts{Unread: unread, Total: total}}
+	}
+
+	return []Maildir{
+		maildir("INBOX", 1, 10),
+		maildir("Lists", 0, 2),
+		maildir("Lists/golang", 3, 5),
+		maildir("Lists/golang/nuts", 2, 4),
+		maildir("Lists/rust", 0, 1),
+		maildir("Work/2020", 4, 8),
+	}
+}
+
+// names returns the keys of the given folders, with their depths.
+func names(folders []*folderNode) string {
+
+	var out []string
+	for _, f := range folders {
+		out = append(out, strings.Repeat(" ", f.depth)+f.key)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestBuildFolderTree(t *testing.T) {
+
+	roots := buildFolderTree(testMaildirs())
+
+	if names(roots) != "INBOX,Lists,Work" {
+		t.Fatalf("unexpected roots: %s", names(roots))
+	}
+
+	// "Work" doesn't exist, so it is a parent with no path.
+	work := roots[2]
+	if work.path != "" || work.name != "Work" || len(work.children) != 1 {
+		t.Errorf("unexpected parent: %+v", work)
+	}
+	if work.children[0].path != "/mail/Work/2020" || work.children[0].name != "2020" {
+		t.Errorf("unexpected child: %+v", work.children[0])
+	}
+
+	type TestCase struct {
+		Folder *folderNode
+		Unread int
+		Total  int
+	}
+
+	lists := roots[1]
+	golang := lists.children[0]
+
+	tests := []TestCase{
+		{roots[0], 1, 10},
+		{golang.children[0], 2, 4},
+		{golang, 5, 9},
+		{lists, 5, 12},
+		{work, 4, 8},
+	}
+
+	for _, tst := range tests {
+		unread, total := tst.Folder.counts()
+		if unread != tst.Unread || total != tst.Total {
+			t.Errorf("%s: expected %d/%d, got %d/%d", tst.Folder.key, tst.Unread, tst.Total, unread, total)
+		}
+	}
+}
+
+func TestVisibleFolders(t *testing.T) {
+
+	type TestCase struct {
+		Expanded []string
+		Visible  string
+	}
+
+	tests := []TestCase{
+		{nil, "INBOX,Lists,Work"},
+		{[]string{"Lists"}, "INBOX,Lists, Lists/golang, Lists/rust,Work"},
+		{[]string{"Lists", "Lists/golang"}, "INBOX,Lists, Lists/golang,  Lists/golang/nuts, Lists/rust,Work"},
+
+		// Children of collapsed folders are hidden, even if they
+		// are expanded themselves.
+		{[]string{"Lists/golang", "Work"}, "INBOX,Lists,Work, Work/2020"},
+	}
+
+	roots := buildFolderTree(testMaildirs())
+
+	for _, tst := range tests {
+
+		expanded := make(map[string]bool)
+		for _, key := range tst.Expanded {
+			expanded[key] = true
+		}
+
+		out := names(visibleFolders(roots, expanded))
+		if out != tst.Visible {
+			t.Errorf("expanded %v: expected %q, got %q", tst.Expanded, tst.Visible, out)
+		}
+	}
+}
+
+func TestExpandedState(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The parent directory is created as needed.
+	path := filepath.Join(dir, "config", "expanded-folders")
+
+	// A missing file means nothing is expanded.
+	if len(loadExpanded(path)) != 0 {
+		t.Fatalf("expected nothing expanded without a state-file")
+	}
+
+	expanded := map[string]bool{"Lists": true, "Lists/golang": true, "Work": false}
+	if err = saveExpanded(path, expanded); err != nil {
+		t.Fatalf("failed to save state: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err)
+	}
+	if string(content) != "Lists\nLists/golang\n" {
+		t.Errorf("unexpected state-file content %q", content)
+	}
+
+	loaded := loadExpanded(path)
+	if len(loaded) != 2 || !loaded["Lists"] || !loaded["Lists/golang"] {
+		t.Errorf("state didn't round-trip: %v", loaded)
+	}
+
+	// Saving nothing empties the file.
+	if err = saveExpanded(path, map[string]bool{}); err != nil {
+		t.Fatalf("failed to save state: %s", err)
+	}
+	if len(loadExpanded(path)) != 0 {
+		t.Errorf("expected nothing expanded after saving nothing")
+	}
+
+	// An empty path disables the state-file.
+	if err = saveExpanded("", expanded); err != nil {
+		t.Errorf("unexpected error without a state-file: %s", err)
+	}
+	if len(loadExpanded("")) != 0 {
+		t.Errorf("expected nothing expanded without a state-file")
+	}
+}
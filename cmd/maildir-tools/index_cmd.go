@@ -0,0 +1,155 @@
+// Manage our cache of message-headers.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/cache"
+	"github.com/skx/maildir-tools/finder"
+)
+
+// indexCmd holds our state
+type indexCmd struct {
+
+	// The prefix to our maildir hierarchy
+	prefix string
+
+	// The separator to use within logical folder-names.
+	separator string
+
+	// The directory our cache-files are stored within.
+	cache string
+}
+
+//
+// Glue
+//
+func (*indexCmd) Name() string     { return "index" }
+func (*indexCmd) Synopsis() string { return "Build, verify, or clear the header cache." }
+func (*indexCmd) Usage() string {
+	return `index build|verify|clear [folder1 .. folderN] :
+  The 'messages', 'maildirs', and 'ui' sub-commands cache the headers of
+ the messages they read, to avoid parsing them again in the future.
+
+  This sub-command allows you to manage that cache:
+
+    build  - Populate the cache, by reading every message.
+    verify - Report on cache-entries which are missing, or outdated.
+    clear  - Remove the cached data.
+
+  If no folders are specified then all maildirs beneath the prefix are
+ processed.
+`
+}
+
+//
+// Flag setup
+//
+func (p *indexCmd) SetFlags(f *flag.FlagSet) {
+	prefix := os.Getenv("HOME") + "/Maildir/"
+
+	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
+	f.StringVar(&p.cache, "cache", cache.DefaultDirectory(), "The directory our cache is stored in.")
+}
+
+// build populates the cache for the given maildir.
+func (p *indexCmd) build(c *cache.Cache, find *finder.Finder, maildir string) error {
+
+	index := c.Open(maildir)
+
+	for _, file := range index.Files(find) {
+		if _, err := index.Email(file); err != nil {
+			return err
+		}
+	}
+
+	return index.Save()
+}
+
+// verify reports upon problems with the cache for the given maildir,
+// returning true if the cache is up to date.
+func (p *indexCmd) verify(c *cache.Cache, find *finder.Finder, maildir string) bool {
+
+	index := c.Open(maildir)
+	problems := index.Verify(find)
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	return len(problems) == 0
+}
+
+//
+// Entry-point.
+//
+func (p *indexCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if len(f.Args()) < 1 {
+		fmt.Printf("Usage: %s", p.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	action := f.Args()[0]
+	folders := f.Args()[1:]
+
+	c := cache.New(p.cache)
+	find := finder.New(p.prefix)
+
+	//
+	// Resolve the folders we've been given.
+	//
+	helper := &messagesCmd{prefix: p.prefix, separator: p.separator}
+	for i, folder := range folders {
+		path, err := helper.getMaildirPath(folder)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		folders[i] = path
+	}
+
+	//
+	// Clearing is special, because we don't need to find the
+	// maildirs - we can just remove all cache-files.
+	//
+	if action == "clear" {
+		if err := c.Clear(folders...); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
+	if len(folders) == 0 {
+		folders = find.Maildirs()
+	}
+
+	ret := subcommands.ExitSuccess
+
+	for _, maildir := range folders {
+
+		switch action {
+		case "build":
+			if err := p.build(c, find, maildir); err != nil {
+				fmt.Printf("%s\n", err.Error())
+				ret = subcommands.ExitFailure
+			}
+		case "verify":
+			if !p.verify(c, find, maildir) {
+				ret = subcommands.ExitFailure
+			}
+		default:
+			fmt.Printf("Unknown action '%s'\n", action)
+			return subcommands.ExitUsageError
+		}
+	}
+
+	return ret
+}
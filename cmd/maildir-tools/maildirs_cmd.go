@@ -9,9 +9,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"strings"
+	"runtime"
 
 	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/cache"
 	"github.com/skx/maildir-tools/finder"
 	"github.com/skx/maildir-tools/formatter"
 )
@@ -23,6 +24,18 @@ type maildirsCmd struct {
 
 	// The root directory to our maildir hierarchy
 	prefix string
+
+	// The directory to cache maildir-listings within, if any.
+	cache string
+
+	// The number of maildirs to process in parallel.
+	jobs int
+
+	// The separator to use within logical folder-names.
+	separator string
+
+	// The structured output, if any, to produce.
+	output structuredOutput
 }
 
 //
@@ -33,6 +46,41 @@ func (*maildirsCmd) Synopsis() string { return "Show maildir folders beneath the
 func (*maildirsCmd) Usage() string {
 	return `maildirs :
   Show maildir folders beneath the given root directory, recursively.
+
+  Folders nested as directories, and Maildir++ folders such as
+ '.Lists.golang', are both supported.  The root directory is shown as
+ INBOX if it is a maildir itself.
+
+  The following fields may be used in the format-string:
+
+    #{name}      - The complete path to the maildir.
+    #{shortname} - The logical name of the folder, such as "Lists/golang".
+    #{total}     - The total number of messages.
+    #{unread}    - The number of unread messages.
+    #{new}       - The number of messages in new/, not yet seen by a client.
+    #{flagged}   - The number of flagged messages.
+    #{replied}   - The number of messages which have been replied to.
+    #{trashed}   - The number of messages marked as trashed.
+    #{draft}     - The number of draft messages.
+
+  A message is unread if it is in new/, or doesn't have the 'S'een flag.
+
+  Rather than using a format-string you may output a record for each
+ folder via '-json', '-jsonl', or '-csv'.  Records have the fields:
+
+    path    - The complete path to the maildir.
+    name    - The logical name of the folder.
+    total   - The total number of messages.
+    unread  - The number of unread messages.
+    new     - The number of messages in new/.
+    flagged - The number of flagged messages.
+    replied - The number of messages which have been replied to.
+    trashed - The number of messages marked as trashed.
+    draft   - The number of draft messages.
+
+  For example:
+
+    maildir-tools maildirs -jsonl | jq -r 'select(.unread > 0) | .name'
 `
 }
 
@@ -45,8 +93,16 @@ func (p *maildirsCmd) SetFlags(f *flag.FlagSet) {
 
 	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
 	f.StringVar(&p.format, "format", "#{06unread}/#{06total} - #{name}", "The format string to display.")
+	f.StringVar(&p.cache, "cache", cache.DefaultDirectory(), "The directory to cache maildir-listings in, set to empty to disable.")
+	f.IntVar(&p.jobs, "jobs", runtime.NumCPU(), "The number of maildirs to count in parallel.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
+	p.output.SetFlags(f, false)
 }
 
+// countFields holds the format-string fields which require the messages
+// in each maildir to be counted.
+var countFields = []string{"total", "unread", "new", "flagged", "replied", "trashed", "draft", "unread_highlight"}
+
 // Maildir is the type of object we return from our main
 // function.
 type Maildir struct {
@@ -54,6 +110,16 @@ type Maildir struct {
 	// Path contains the complete path to the maildir.
 	Path string
 
+	// Name contains the logical name of the maildir.
+	Name string
+
+	// Parts contains the components of the logical name.
+	Parts []string
+
+	// Counts contains the number of messages in each state, such
+	// as Unread and Total - if they were counted.
+	finder.Counts
+
 	// Rendered contains the maildir formated via the
 	// supplied format-string.
 	Rendered string
@@ -72,21 +138,21 @@ func (p *maildirsCmd) GetMaildirs() []Maildir {
 	//
 	// Find the maildir entries beneath our prefix directory.
 	//
-	finder := finder.New(p.prefix)
-	maildirs := finder.Maildirs()
+	find := finder.New(p.prefix)
+	find.Separator = p.separator
+	maildirs := find.Folders()
+
+	//
+	// Parse our format-string once, for all the maildirs.
+	//
+	tmpl := formatter.Compile(p.format)
 
 	//
 	// Do we need to count the files inside our maildirs?
 	//
 	// If we can avoid it that speeds things up :)
 	//
-	count := false
-	countFormats := []string{"total", "unread", "unread_highlight"}
-	for _, tmp := range countFormats {
-		if strings.Contains(p.format, tmp) {
-			count = true
-		}
-	}
+	count := p.output.enabled() || tmpl.Uses(countFields...)
 
 	//
 	// Now we know how many results to expect.
@@ -94,38 +160,37 @@ func (p *maildirsCmd) GetMaildirs() []Maildir {
 	results = make([]Maildir, len(maildirs))
 
 	//
-	// Build up the formatted results.
+	// Build up the formatted results, in parallel.
 	//
-	for index, ent := range maildirs {
+	parallel(len(maildirs), p.jobs, func(index int) {
+
+		folder := maildirs[index]
+		ent := folder.Path
 
 		//
-		// Count of unread and total messages in the
+		// Count of messages in each state, in the
 		// maildir.  These might not be used.
 		//
-		unread := 0
-		total := 0
+		var counts finder.Counts
 
 		//
 		// Count files if we're supposed to
 		//
+		// The state of each message comes from its filename,
+		// so we never need to open them.
+		//
 		if count {
-			messages := finder.Messages(ent)
-			total = len(messages)
-
-			for _, entry := range messages {
-
-				// TODO - Fix me
-				//
-				// A message is unread if EITHER
-				//
-				// A) it is in the new/ folder
-				//
-				// B) It does NOT have the `S`een flag.
-				//
-				if strings.Contains(entry, "/new/") {
-					unread++
+			var messages []string
+			if p.cache != "" {
+				cached := cache.New(p.cache).Open(ent)
+				for _, file := range cached.Files(find) {
+					messages = append(messages, file.Path)
 				}
+				cached.Save()
+			} else {
+				messages = find.Messages(ent)
 			}
+			counts = finder.CountStates(messages)
 		}
 
 		//
@@ -139,14 +204,25 @@ func (p *maildirsCmd) GetMaildirs() []Maildir {
 			case "name":
 				ret = ent
 			case "shortname":
-				ret = ent[len(p.prefix):]
+				ret = folder.Name
 			case "total":
-				ret = fmt.Sprintf("%d", total)
+				ret = fmt.Sprintf("%d", counts.Total)
 			case "unread":
-				ret = fmt.Sprintf("%d", unread)
+				ret = fmt.Sprintf("%d", counts.Unread)
+			case "new":
+				ret = fmt.Sprintf("%d", counts.New)
+			case "flagged":
+				ret = fmt.Sprintf("%d", counts.Flagged)
+			case "replied":
+				ret = fmt.Sprintf("%d", counts.Replied)
+			case "trashed":
+				ret = fmt.Sprintf("%d", counts.Trashed)
+			case "draft":
+				ret = fmt.Sprintf("%d", counts.Draft)
 			case "unread_highlight":
-				// Highlighting for UI
-				if unread > 0 {
+				// Retained for existing format-strings, which
+				// should now use "#{?unread>0:[red]}".
+				if counts.Unread > 0 {
 					return "[red]"
 				}
 				return ""
@@ -160,8 +236,12 @@ func (p *maildirsCmd) GetMaildirs() []Maildir {
 		//
 		// Save the results
 		//
-		results[index] = Maildir{Path: ent, Rendered: formatter.Expand(p.format, mapper)}
-	}
+		results[index] = Maildir{Path: ent,
+			Name:     folder.Name,
+			Parts:    folder.Parts,
+			Counts:   counts,
+			Rendered: tmpl.Render(mapper)}
+	})
 
 	return results
 }
@@ -171,11 +251,49 @@ func (p *maildirsCmd) GetMaildirs() []Maildir {
 //
 func (p *maildirsCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	if err := p.output.validate(); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitUsageError
+	}
+
 	//
 	// Get all the maildirs we know about
 	//
 	maildirs := p.GetMaildirs()
 
+	//
+	// Output structured records, if we should.
+	//
+	if p.output.enabled() {
+
+		var records []interface{}
+		var rows [][]string
+		for _, ent := range maildirs {
+			records = append(records, MaildirRecord{Path: ent.Path,
+				Name:    ent.Name,
+				Total:   ent.Total,
+				Unread:  ent.Unread,
+				New:     ent.New,
+				Flagged: ent.Flagged,
+				Replied: ent.Replied,
+				Trashed: ent.Trashed,
+				Draft:   ent.Draft})
+
+			row := []string{ent.Path, ent.Name}
+			for _, n := range []int{ent.Total, ent.Unread, ent.New, ent.Flagged, ent.Replied, ent.Trashed, ent.Draft} {
+				row = append(row, fmt.Sprintf("%d", n))
+			}
+			rows = append(rows, row)
+		}
+
+		columns := []string{"path", "name", "total", "unread", "new", "flagged", "replied", "trashed", "draft"}
+		if err := p.output.write(records, columns, rows); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
 	//
 	// For each one, show the formatted output
 	//
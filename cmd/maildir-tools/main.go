@@ -19,11 +19,18 @@ func main() {
 	subcommands.Register(subcommands.CommandsCommand(), "")
 
 	// Our commands
+	subcommands.Register(&attachmentsCmd{}, "")
+	subcommands.Register(&moveCmd{copy: true}, "")
+	subcommands.Register(&flagCmd{}, "")
+	subcommands.Register(&indexCmd{}, "")
 	subcommands.Register(&maildirsCmd{}, "")
 	subcommands.Register(&messagesCmd{}, "")
 	subcommands.Register(&messageCmd{}, "")
+	subcommands.Register(&moveCmd{}, "")
 	subcommands.Register(&versionCmd{}, "")
+	subcommands.Register(&searchCmd{}, "")
 	subcommands.Register(&uiCmd{}, "")
+	subcommands.Register(&watchCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
@@ -8,17 +8,21 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"text/template"
 
 	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/formatter"
 	"github.com/skx/maildir-tools/mailreader"
 )
 
 var (
 	defaultTemplate = `To: {{.To}}
 From: {{.From}}
-Cc: {{.Cc}}
-Date: {{.Date}}
+{{if .Cc}}Cc: {{.Cc}}
+{{end}}Date: {{.Date}}
 Subject: {{.Subject}}
 
 {{.Body}}`
@@ -32,6 +36,12 @@ type messageCmd struct {
 
 	// If this flag is true we just dump our template
 	dumpTemplate bool
+
+	// If this flag is true we show the MIME structure of the message
+	structure bool
+
+	// The structured output, if any, to produce.
+	output structuredOutput
 }
 
 //
@@ -44,6 +54,25 @@ func (*messageCmd) Usage() string {
   Show a single formatted message.  By default an internal template
  will be used, but you may specify the filename of a Golang text/template
  file to use for rendering if you wish.
+
+  See the README for the fields, and functions, available to templates.
+
+  Rather than using a template you may output a record for each message
+ via '-json', '-jsonl', or '-csv'.  Records have the fields:
+
+    path        - The path to the message.
+    maildir     - The path to the maildir containing the message.
+    flags       - The flags of the message.
+    size        - The size of the message, in bytes.
+    headers     - An object of the (decoded) headers of the message.
+    body        - The text of the message.
+    body_html   - The raw text/html part of the message, if any.
+    attachments - An array of the attachments, each of which has the
+                  fields filename, content_type, content_id, inline,
+                  and size.
+
+  All headers are included, unless you choose specific ones via '-headers'.
+ CSV output omits the attachments.
 `
 }
 
@@ -53,6 +82,70 @@ func (*messageCmd) Usage() string {
 func (p *messageCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.template, "template", "", "Specify the path to a golang text/template file to use for message-rendering")
 	f.BoolVar(&p.dumpTemplate, "dump-template", false, "Dump the default template")
+	f.BoolVar(&p.structure, "structure", false, "Show the MIME structure of the message, rather than its content")
+	p.output.SetFlags(f, true)
+}
+
+// GetStructure returns the MIME structure of the specified email, as
+// a tree similar to mutt's attachment-view.
+func (p *messageCmd) GetStructure(path string) (string, error) {
+
+	helper, err := mailreader.NewEnmime(path)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := helper.Structure()
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	index := 0
+
+	// Show each part, recursively, with the prefix used to
+	// draw the tree-branches.
+	var show func(part *mailreader.Part, prefix string, branch string)
+	show = func(part *mailreader.Part, prefix string, branch string) {
+
+		index++
+
+		disposition := "I"
+		if part.Disposition == "attachment" {
+			disposition = "A"
+		}
+
+		name := part.Filename
+		if name == "" {
+			name = "<no description>"
+		}
+
+		details := []string{part.ContentType, part.Encoding}
+		if part.Charset != "" {
+			details = append(details, part.Charset)
+		}
+		details = append(details, formatter.HumanSize(int64(part.Size)))
+
+		fmt.Fprintf(&out, "%s %4d %s%s [%s]\n", disposition, index, prefix+branch, name, strings.Join(details, ", "))
+
+		// The prefix for our children continues our own branch.
+		if branch == "├─>" {
+			prefix += "│  "
+		} else if branch == "└─>" {
+			prefix += "   "
+		}
+
+		for i, child := range part.Children {
+			if i == len(part.Children)-1 {
+				show(child, prefix, "└─>")
+			} else {
+				show(child, prefix, "├─>")
+			}
+		}
+	}
+	show(root, "", "")
+
+	return strings.TrimSuffix(out.String(), "\n"), nil
 }
 
 // Show the specified email, with the appropriate template
@@ -69,16 +162,6 @@ func (p *messageCmd) GetMessage(path string) (string, error) {
 		tmpl = string(content)
 	}
 
-	// This is the structure we'll use to populate that
-	// template with.
-	type Message struct {
-		To      string
-		From    string
-		Subject string
-		Date    string
-		Body    string
-	}
-
 	//
 	// Parse the message - note that this is gross.
 	//
@@ -88,23 +171,106 @@ func (p *messageCmd) GetMessage(path string) (string, error) {
 	}
 
 	//
-	// Populate the instance
+	// Populate the data for our template.
 	//
-	var data Message
-	data.Subject = helper.Header("Subject")
-	data.To = helper.Header("To")
-	data.From = helper.Header("From")
-	data.Date = helper.Header("Date")
-	data.Body = helper.Body()
+	data, err := NewMessage(helper)
+	if err != nil {
+		return "", err
+	}
 
 	// Render.
+	t, err := template.New("view.tmpl").Funcs(templateFunctions()).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
 	var out bytes.Buffer
-	t := template.Must(template.New("view.tmpl").Parse(tmpl))
 	err = t.Execute(&out, data)
 
 	return out.String(), err
 }
 
+// GetContent returns the structured representation of the specified
+// email, including only the given headers.
+func (p *messageCmd) GetContent(email *mailreader.Email, headers []string) (MessageContent, error) {
+
+	attachments, err := email.Attachments()
+	if err != nil {
+		return MessageContent{}, err
+	}
+
+	content := MessageContent{Path: email.Filename,
+		Maildir:     filepath.Dir(filepath.Dir(email.Filename)),
+		Flags:       email.Flags(),
+		Size:        messageSize(email.Filename),
+		Headers:     p.output.headerValues(email, headers),
+		Body:        email.Body(),
+		BodyHTML:    email.BodyHTML(),
+		Attachments: []AttachmentRecord{}}
+
+	for _, a := range attachments {
+		content.Attachments = append(content.Attachments, AttachmentRecord{Filename: a.Filename,
+			ContentType: a.ContentType,
+			ContentID:   a.ContentID,
+			Inline:      a.Inline,
+			Size:        a.Size})
+	}
+
+	return content, nil
+}
+
+// writeRecords outputs the given messages as structured records.
+//
+// Messages which can't be read are reported, but don't prevent the
+// others from being output.
+func (p *messageCmd) writeRecords(paths []string) subcommands.ExitStatus {
+
+	var emails []*mailreader.Email
+	for _, path := range paths {
+		email, err := mailreader.NewEnmime(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			continue
+		}
+		emails = append(emails, email)
+	}
+
+	headers := p.output.headerNames(emails)
+
+	var records []interface{}
+	var rows [][]string
+
+	for _, email := range emails {
+
+		content, err := p.GetContent(email, headers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			continue
+		}
+		records = append(records, content)
+
+		row := []string{content.Path,
+			content.Maildir,
+			content.Flags,
+			fmt.Sprintf("%d", content.Size)}
+		for _, name := range headers {
+			row = append(row, headerCell(content.Headers[name]))
+		}
+		row = append(row, content.Body, content.BodyHTML)
+		rows = append(rows, row)
+	}
+
+	columns := []string{"path", "maildir", "flags", "size"}
+	columns = append(columns, headers...)
+	columns = append(columns, "body", "body_html")
+
+	if err := p.output.write(records, columns, rows); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
 // Entry-point.
 func (p *messageCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
@@ -113,8 +279,23 @@ func (p *messageCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		return subcommands.ExitSuccess
 	}
 
+	if err := p.output.validate(); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitUsageError
+	}
+
+	if p.output.enabled() {
+		return p.writeRecords(f.Args())
+	}
+
 	for _, path := range f.Args() {
-		out, err := p.GetMessage(path)
+
+		get := p.GetMessage
+		if p.structure {
+			get = p.GetStructure
+		}
+
+		out, err := get(path)
 		if err != nil {
 			fmt.Printf("%s\n", err.Error())
 		} else {
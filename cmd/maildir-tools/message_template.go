@@ -0,0 +1,204 @@
+// The data, and helper-functions, available to message-templates.
+
+package main
+
+import (
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// Message is the structure we use to populate message-templates.
+//
+// The common headers are available as fields, but any header can be
+// retrieved via the Header method, for example `{{.Header "X-Mailer"}}`.
+type Message struct {
+
+	// To, From, Cc, Subject, and Date hold the (decoded) values of
+	// the corresponding headers.
+	To      string
+	From    string
+	Cc      string
+	Subject string
+	Date    string
+
+	// ToList, FromList, and CcList hold the parsed addresses from the
+	// corresponding headers.  Each entry has a Name and Address field.
+	ToList   []*mail.Address
+	FromList []*mail.Address
+	CcList   []*mail.Address
+
+	// Time holds the parsed value of the Date-header, which will be
+	// the zero time if it could not be parsed.
+	Time time.Time
+
+	// Flags holds the flags of the message.
+	Flags string
+
+	// Filename holds the path to the message on-disk.
+	Filename string
+
+	// Maildir holds the path to the maildir containing the message.
+	Maildir string
+
+	// Body holds the text/plain part of the message, or the converted
+	// text/html part if there is no text/plain part.
+	Body string
+
+	// BodyText is the same as Body, but empty if there is no body.
+	BodyText string
+
+	// BodyHTML holds the raw text/html part of the message, if any.
+	BodyHTML string
+
+	// Attachments holds the attachments, and inline parts.
+	Attachments []mailreader.Attachment
+
+	// Parts holds all the MIME parts of the message, in depth-first
+	// order.
+	Parts []*mailreader.Part
+
+	// email holds the message we were created from.
+	email *mailreader.Email
+}
+
+// NewMessage creates the template-data for the given message.
+func NewMessage(email *mailreader.Email) (*Message, error) {
+
+	if err := email.Upgrade(); err != nil {
+		return nil, err
+	}
+
+	m := &Message{email: email}
+
+	m.To = email.Header("To")
+	m.From = email.Header("From")
+	m.Cc = email.Header("Cc")
+	m.Subject = email.Header("Subject")
+	m.Date = email.Header("Date")
+
+	// Broken addresses, or dates, aren't fatal.
+	m.ToList, _ = email.Addresses("To")
+	m.FromList, _ = email.Addresses("From")
+	m.CcList, _ = email.Addresses("Cc")
+	m.Time, _ = email.Date()
+
+	m.Flags = email.Flags()
+	m.Filename = email.Filename
+	m.Maildir = filepath.Dir(filepath.Dir(email.Filename))
+
+	m.Body = email.Body()
+	m.BodyText = email.BodyText()
+	m.BodyHTML = email.BodyHTML()
+
+	var err error
+	m.Attachments, err = email.Attachments()
+	if err != nil {
+		return nil, err
+	}
+
+	structure, err := email.Structure()
+	if err != nil {
+		return nil, err
+	}
+	m.Parts = structure.Flatten()
+
+	return m, nil
+}
+
+// Header returns the (decoded) value of the named header.
+func (m *Message) Header(name string) string {
+	return m.email.Header(name)
+}
+
+// Headers returns the names of all the headers in the message.
+func (m *Message) Headers() []string {
+	return m.email.HeaderNames()
+}
+
+// Addresses returns the parsed addresses from the named header.
+func (m *Message) Addresses(name string) []*mail.Address {
+	list, _ := m.email.Addresses(name)
+	return list
+}
+
+// templateFunctions returns the helper-functions available to
+// message-templates.
+//
+//   wrap N text     - Word-wrap the text to N columns.
+//   quote text      - Prefix each line of the text with "> ".
+//   date layout t   - Format a time.Time with a Go time-layout.
+func templateFunctions() template.FuncMap {
+	return template.FuncMap{
+		"wrap":  wrap,
+		"quote": quote,
+		"date":  formatDate,
+	}
+}
+
+// wrap word-wraps the given text to the specified width, measured in
+// terminal columns rather than bytes.
+//
+// Existing line-breaks are preserved, as are quoted lines, which start
+// with ">", since rewrapping those would lose their structure.
+func wrap(width int, text string) string {
+
+	var out []string
+
+	for _, line := range strings.Split(text, "\n") {
+
+		if runewidth.StringWidth(line) <= width || strings.HasPrefix(line, ">") {
+			out = append(out, line)
+			continue
+		}
+
+		cur := ""
+		curWidth := 0
+		for _, word := range strings.Fields(line) {
+			w := runewidth.StringWidth(word)
+			if cur != "" && curWidth+1+w > width {
+				out = append(out, cur)
+				cur = ""
+				curWidth = 0
+			}
+			if cur != "" {
+				cur += " "
+				curWidth++
+			}
+			cur += word
+			curWidth += w
+		}
+		out = append(out, cur)
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// quote prefixes each line of the given text with "> ", as when
+// replying to a message.
+func quote(text string) string {
+
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatDate formats the given time with the given layout, returning
+// an empty string for the zero time.
+func formatDate(layout string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
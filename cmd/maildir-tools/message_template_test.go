@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWrap(t *testing.T) {
+
+	type TestCase struct {
+		Width  int
+		Input  string
+		Output string
+	}
+
+	tests := []TestCase{
+		{10, "short", "short"},
+		{10, "the quick brown fox jumps", "the quick\nbrown fox\njumps"},
+		{10, "one\ntwo three four five", "one\ntwo three\nfour five"},
+		{10, "> a quoted line which is long", "> a quoted line which is long"},
+		{5, "extraordinary words", "extraordinary\nwords"},
+		{10, "", ""},
+
+		// Widths are measured in columns, not bytes.
+		{10, "café crème brûlée", "café crème\nbrûlée"},
+		{6, "日本語 日本語", "日本語\n日本語"},
+		{13, "日本語 日本語", "日本語 日本語"},
+	}
+
+	for _, tst := range tests {
+		out := wrap(tst.Width, tst.Input)
+		if out != tst.Output {
+			t.Errorf("wrap(%d, %q): expected %q, got %q", tst.Width, tst.Input, tst.Output, out)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+
+	type TestCase struct {
+		Input  string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"hello", "> hello"},
+		{"hello\nworld\n", "> hello\n> world"},
+		{"> already quoted", ">> already quoted"},
+		{"line\n\nafter blank", "> line\n> \n> after blank"},
+	}
+
+	for _, tst := range tests {
+		out := quote(tst.Input)
+		if out != tst.Output {
+			t.Errorf("quote(%q): expected %q, got %q", tst.Input, tst.Output, out)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+
+	type TestCase struct {
+		Layout string
+		Time   time.Time
+		Output string
+	}
+
+	when := time.Date(2020, 1, 8, 9, 30, 0, 0, time.UTC)
+
+	tests := []TestCase{
+		{"2006-01-02", when, "2020-01-08"},
+		{"Mon, 2 Jan 2006 15:04", when, "Wed, 8 Jan 2020 09:30"},
+		{"2006-01-02", time.Time{}, ""},
+	}
+
+	for _, tst := range tests {
+		out := formatDate(tst.Layout, tst.Time)
+		if out != tst.Output {
+			t.Errorf("formatDate(%q, %v): expected %q, got %q", tst.Layout, tst.Time, tst.Output, out)
+		}
+	}
+}
@@ -8,12 +8,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/cache"
 	"github.com/skx/maildir-tools/finder"
 	"github.com/skx/maildir-tools/formatter"
 	"github.com/skx/maildir-tools/mailreader"
+	"github.com/skx/maildir-tools/sorter"
+	"github.com/skx/maildir-tools/thread"
 )
 
 // messageCmd holds the state for this sub-command
@@ -22,8 +26,26 @@ type messagesCmd struct {
 	// The prefix to our maildir hierarchy
 	prefix string
 
+	// The separator to use within logical folder-names.
+	separator string
+
 	// The format-string to use for displaying messages
 	format string
+
+	// The directory to cache message-headers within, if any.
+	cache string
+
+	// The number of messages to parse in parallel.
+	jobs int
+
+	// Should messages be grouped into threads?
+	threaded bool
+
+	// The order to sort messages in.
+	sort string
+
+	// The structured output, if any, to produce.
+	output structuredOutput
 }
 
 // SingleMessage holds the state for a single message
@@ -35,6 +57,17 @@ type SingleMessage struct {
 	// Rendered contains the rendered result of using
 	// a format-string to output the message.
 	Rendered string
+
+	// Thread holds the number of the thread the message is part of.
+	//
+	// When messages aren't threaded each is a thread of its own.
+	Thread int
+
+	// Depth holds the depth of the message within its thread.
+	Depth int
+
+	// email holds the parsed message.
+	email *mailreader.Email
 }
 
 //
@@ -45,6 +78,43 @@ func (*messagesCmd) Synopsis() string { return "Show the messages in the given d
 func (*messagesCmd) Usage() string {
 	return `messages :
   Show the messages in the specified maildir folder.
+
+  If '-threaded' is given the messages are grouped into conversations,
+ and the following additional fields may be used in the format-string:
+
+    #{thread_tree}  - The branches of the thread, drawn in ASCII.
+    #{thread_depth} - The depth of the message in its thread.
+    #{thread_count} - The number of messages in the thread.
+
+  Messages are sorted by the modification-time of their files, but you
+ may choose a different order via '-sort':
+
+    mtime, date, arrival, from, subject, size, or flags
+
+  Any of which may be prefixed with 'reverse-' to reverse the order.  When
+ threading, threads are shown in the order of their first message.
+
+  For example:
+
+    maildir-tools messages -threaded -format '#{thread_tree}#{subject}' lists
+
+  Rather than using a format-string you may output a record for each
+ message via '-json', '-jsonl', or '-csv'.  Records have the fields:
+
+    index   - The position of the message in the listing, from 1.
+    path    - The path to the message.
+    maildir - The path to the maildir containing the message.
+    folder  - The logical name of the maildir.
+    flags   - The flags of the message.
+    size    - The size of the message, in bytes.
+    thread  - The number of the thread the message is part of.
+    depth   - The depth of the message within its thread.
+    headers - An object of the (decoded) headers of the message.
+
+  All headers are included, unless you choose specific ones via '-headers'.
+ In CSV output each header is a column of its own, for example:
+
+    maildir-tools messages -csv -headers From,Subject lists
 `
 }
 
@@ -55,7 +125,13 @@ func (p *messagesCmd) SetFlags(f *flag.FlagSet) {
 	prefix := os.Getenv("HOME") + "/Maildir/"
 
 	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
 	f.StringVar(&p.format, "format", "[#{index}/#{total} - #{5flags}] #{subject}", "Specify the format-string to use for the message-display")
+	f.StringVar(&p.cache, "cache", cache.DefaultDirectory(), "The directory to cache message-headers in, set to empty to disable.")
+	f.IntVar(&p.jobs, "jobs", runtime.NumCPU(), "The number of messages to parse in parallel.")
+	f.BoolVar(&p.threaded, "threaded", false, "Group the messages into conversations.")
+	f.StringVar(&p.sort, "sort", "mtime", "The order to sort messages in.")
+	p.output.SetFlags(f, true)
 }
 
 // Find the absolute path to the given maildir folder
@@ -78,20 +154,27 @@ func (p *messagesCmd) getMaildirPath(input string) (string, error) {
 		}
 	}
 
+	//
+	// Finally look for a folder with the given logical name.
+	//
+	find := finder.New(p.prefix)
+	if p.separator != "" {
+		find.Separator = p.separator
+	}
+	if folder, ok := find.Folder(input); ok {
+		return folder.Path, nil
+	}
+
 	// No match
 	return "", fmt.Errorf("maildir '%s' wasn't found", input)
 }
 
-// Get the messages in the given folder.
+// GetEmails returns the parsed messages in the given folder, sorted in
+// our configured order.
 //
 // If the path is absolute it will be used as-is, otherwise we'll hunt
 // for it beneath our configured prefix.
-func (p *messagesCmd) GetMessages(path string, format string) ([]SingleMessage, error) {
-
-	//
-	// The messages we'll find
-	//
-	var messages []SingleMessage
+func (p *messagesCmd) GetEmails(path string) ([]*mailreader.Email, error) {
 
 	//
 	// Get the fully-qualified path to the given maildir
@@ -102,71 +185,238 @@ func (p *messagesCmd) GetMessages(path string, format string) ([]SingleMessage,
 	//
 	path, err := p.getMaildirPath(path)
 	if err != nil {
-		return messages, err
+		return nil, err
+	}
+
+	//
+	// Test our sort-order before we do any work.
+	//
+	if err = sorter.Valid(p.sort); err != nil {
+		return nil, err
 	}
 
 	//
 	// Helper for finding messages.
 	//
-	finder := finder.New(p.prefix)
+	find := finder.New(p.prefix)
 
 	//
-	// Find the messages
+	// Find the messages, using our cache if we can.
 	//
-	files := finder.Messages(path)
+	var cached *cache.Maildir
+	var files []finder.MessageFile
+	if p.cache != "" {
+		cached = cache.New(p.cache).Open(path)
+		files = cached.Files(find)
+	} else {
+		files = find.MessageFiles(path)
+	}
 
 	//
 	// We know how many messages to expect now.
 	//
-	messages = make([]SingleMessage, len(files))
+	emails := make([]*mailreader.Email, len(files))
 
 	//
-	// For each file - parse the email message and generate a summary.
+	// Any errors encountered while parsing, by index.
 	//
-	for index, msg := range files {
+	errs := make([]error, len(files))
 
-		//
-		// Read the mail, so we can access the data.
-		//
-		mail, err := mailreader.New(msg)
+	//
+	// For each file - parse the email message.
+	//
+	// We do this in parallel, storing the results by index, so the
+	// output order is unchanged.
+	//
+	parallel(len(files), p.jobs, func(index int) {
+
+		file := files[index]
+
+		if cached != nil {
+			emails[index], errs[index] = cached.Email(file)
+		} else {
+			emails[index], errs[index] = mailreader.New(file.Path)
+		}
+	})
+
+	//
+	// Report the first error, if any.
+	//
+	for _, err := range errs {
 		if err != nil {
-			return messages, err
+			return nil, err
 		}
+	}
 
-		//
-		// Expand the template-string
-		//
-		headerMapper := func(field string) string {
-
-			ret := ""
-
-			switch field {
-			case "unread_highlight":
-				// Allow highlighting in the UI
-				if strings.Contains(mail.Flags(), "N") {
-					return "[red]"
-				}
-				return ""
-			case "flags":
-				ret = mail.Flags()
-			case "file":
-				ret = msg
-			case "index":
-				ret = fmt.Sprintf("%d", index+1)
-			case "total":
-				ret = fmt.Sprintf("%d", len(files))
-			default:
-				ret = mail.Header(field)
-			}
-
-			return ret
+	//
+	// Update the cache.
+	//
+	// Failing to do so isn't fatal, it just means we'll be
+	// slower next time.
+	//
+	if cached != nil {
+		cached.Save()
+	}
+
+	//
+	// Sort the messages.
+	//
+	messages := make([]sorter.Message, len(emails))
+	for i, mail := range emails {
+		messages[i] = mail
+	}
+
+	order, err := sorter.Sort(p.sort, messages, files)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*mailreader.Email, len(emails))
+	for i, index := range order {
+		sorted[i] = emails[index]
+	}
+
+	return sorted, nil
+}
+
+// messageFields holds the fields available to message format-strings
+// which don't come from the headers of the message, so don't require
+// them to be read.
+//
+// Each is given the message, its index in a listing, and the size of
+// that listing.
+var messageFields = map[string]func(mail *mailreader.Email, index int, total int) string{
+	"unread_highlight": func(mail *mailreader.Email, _ int, _ int) string {
+		// Retained for existing format-strings, which
+		// should now use "#{?flags~N:[red]}".
+		if strings.Contains(mail.Flags(), "N") {
+			return "[red]"
 		}
+		return ""
+	},
+	"flags": func(mail *mailreader.Email, _ int, _ int) string {
+		return mail.Flags()
+	},
+	"file": func(mail *mailreader.Email, _ int, _ int) string {
+		return mail.Filename
+	},
+	"index": func(_ *mailreader.Email, index int, _ int) string {
+		return fmt.Sprintf("%d", index+1)
+	},
+	"total": func(_ *mailreader.Email, _ int, total int) string {
+		return fmt.Sprintf("%d", total)
+	},
+	"size": func(mail *mailreader.Email, _ int, _ int) string {
+		return fmt.Sprintf("%d", messageSize(mail.Filename))
+	},
+}
+
+// messageMapper returns the function used to expand a format-string
+// for the given message, which is at the given index of a listing.
+//
+// Fields which aren't in messageFields are headers.
+func messageMapper(mail *mailreader.Email, index int, total int) func(string) string {
+
+	return func(field string) string {
+
+		if f, ok := messageFields[field]; ok {
+			return f(mail, index, total)
+		}
+		return mail.Header(field)
+	}
+}
+
+// threadMapper returns the function used to expand a format-string for
+// a message which is part of a thread, falling back to the given mapper
+// for fields which aren't thread-specific.
+func threadMapper(entry thread.Entry, mapper func(string) string) func(string) string {
+
+	return func(field string) string {
+
+		switch field {
+		case "thread_tree":
+			return entry.Tree
+		case "thread_depth":
+			return fmt.Sprintf("%d", entry.Depth)
+		case "thread_count":
+			return fmt.Sprintf("%d", entry.Count)
+		}
+
+		return mapper(field)
+	}
+}
+
+// threadEmails groups the given messages into threads, returning them
+// in display-order.
+func threadEmails(emails []*mailreader.Email) []thread.Entry {
+
+	messages := make([]thread.Message, len(emails))
+	for i, mail := range emails {
+		messages[i] = mail
+	}
+
+	return thread.Flatten(thread.Thread(messages))
+}
+
+// Get the messages in the given folder.
+//
+// If the path is absolute it will be used as-is, otherwise we'll hunt
+// for it beneath our configured prefix.
+func (p *messagesCmd) GetMessages(path string, format string) ([]SingleMessage, error) {
+
+	//
+	// The messages we'll find
+	//
+	var messages []SingleMessage
+
+	//
+	// Read the messages.
+	//
+	emails, err := p.GetEmails(path)
+	if err != nil {
+		return messages, err
+	}
+
+	//
+	// We know how many messages to expect now.
+	//
+	messages = make([]SingleMessage, len(emails))
+
+	//
+	// Parse our format-string once, for all the messages.
+	//
+	tmpl := formatter.Compile(format)
+
+	//
+	// For each message generate a summary.
+	//
+	// If we're threading we output the messages in thread-order.
+	//
+	if p.threaded {
+		for index, entry := range threadEmails(emails) {
+
+			mail := emails[entry.Index]
+			mapper := threadMapper(entry, messageMapper(mail, index, len(emails)))
+
+			messages[index] = SingleMessage{Path: mail.Filename,
+				Rendered: tmpl.Render(mapper),
+				Thread:   entry.Thread,
+				Depth:    entry.Depth,
+				email:    mail}
+		}
+
+		return messages, nil
+	}
+
+	for index, mail := range emails {
 
 		//
 		// Record the entry.
 		//
-		messages[index] = SingleMessage{Path: msg,
-			Rendered: formatter.Expand(format, headerMapper)}
+		messages[index] = SingleMessage{Path: mail.Filename,
+			Rendered: tmpl.Render(messageMapper(mail, index, len(emails))),
+			Thread:   index,
+			email:    mail}
 	}
 
 	//
@@ -180,6 +430,15 @@ func (p *messagesCmd) GetMessages(path string, format string) ([]SingleMessage,
 //
 func (p *messagesCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	if err := p.output.validate(); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitUsageError
+	}
+
+	if p.output.enabled() {
+		return p.writeRecords(f.Args())
+	}
+
 	for _, path := range f.Args() {
 
 		messages, err := p.GetMessages(path, p.format)
@@ -193,3 +452,70 @@ func (p *messagesCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface
 	}
 	return subcommands.ExitSuccess
 }
+
+// writeRecords outputs the messages in the given folders as structured
+// records.
+func (p *messagesCmd) writeRecords(paths []string) subcommands.ExitStatus {
+
+	var messages []SingleMessage
+	var emails []*mailreader.Email
+	var indexes []int
+
+	for _, path := range paths {
+
+		found, err := p.GetMessages(path, "")
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		for index, ent := range found {
+			messages = append(messages, ent)
+			emails = append(emails, ent.email)
+			indexes = append(indexes, index+1)
+		}
+	}
+
+	find := finder.New(p.prefix)
+	if p.separator != "" {
+		find.Separator = p.separator
+	}
+	headers := p.output.headerNames(emails)
+
+	var records []interface{}
+	var rows [][]string
+
+	for i, ent := range messages {
+
+		maildir := filepath.Dir(filepath.Dir(ent.Path))
+		record := MessageRecord{Index: indexes[i],
+			Path:    ent.Path,
+			Maildir: maildir,
+			Folder:  find.Name(maildir),
+			Flags:   ent.email.Flags(),
+			Size:    messageSize(ent.Path),
+			Thread:  ent.Thread,
+			Depth:   ent.Depth,
+			Headers: p.output.headerValues(ent.email, headers)}
+		records = append(records, record)
+
+		row := []string{fmt.Sprintf("%d", record.Index),
+			record.Path,
+			record.Maildir,
+			record.Folder,
+			record.Flags,
+			fmt.Sprintf("%d", record.Size),
+			fmt.Sprintf("%d", record.Thread),
+			fmt.Sprintf("%d", record.Depth)}
+		for _, name := range headers {
+			row = append(row, headerCell(record.Headers[name]))
+		}
+		rows = append(rows, row)
+	}
+
+	columns := append([]string{"index", "path", "maildir", "folder", "flags", "size", "thread", "depth"}, headers...)
+	if err := p.output.write(records, columns, rows); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,111 @@
+// Move, or copy, messages into another maildir.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// moveCmd holds our state
+type moveCmd struct {
+
+	// The prefix to our maildir hierarchy
+	prefix string
+
+	// The separator to use within logical folder-names.
+	separator string
+
+	// Copy the messages, rather than moving them?
+	copy bool
+}
+
+//
+// Glue
+//
+func (p *moveCmd) Name() string {
+	if p.copy {
+		return "copy"
+	}
+	return "move"
+}
+func (p *moveCmd) Synopsis() string {
+	if p.copy {
+		return "Copy messages into another maildir."
+	}
+	return "Move messages into another maildir."
+}
+func (p *moveCmd) Usage() string {
+	return p.Name() + ` folder file1 .. fileN :
+  Deliver the given message-files into the named maildir folder, which may
+ be a path or a logical name such as "Lists/golang".
+
+  Copies are given a new unique-name, while moved messages keep theirs,
+ so 'watch' reports them as moves.  Either way each keeps its flags, and
+ the new path of each is printed so that scripts may keep track of them.
+
+  Messages are written into the tmp/ directory of the folder, synced to
+ disk, and then renamed into place - so they're never seen partially
+ written, and this works across filesystems.  When moving, the original
+ is only removed once the copy is safely on-disk.
+
+  For example:
+
+    maildir-tools ` + p.Name() + ` Archive ~/Maildir/cur/1234.example.org:2,S
+`
+}
+
+//
+// Flag setup
+//
+func (p *moveCmd) SetFlags(f *flag.FlagSet) {
+	prefix := os.Getenv("HOME") + "/Maildir/"
+
+	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
+}
+
+//
+// Entry-point.
+//
+func (p *moveCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	args := f.Args()
+	if len(args) < 2 {
+		fmt.Printf("Usage: %s", p.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	helper := &messagesCmd{prefix: p.prefix, separator: p.separator}
+	maildir, err := helper.getMaildirPath(args[0])
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
+	deliver := mailreader.Move
+	if p.copy {
+		deliver = mailreader.Copy
+	}
+
+	ret := subcommands.ExitSuccess
+
+	for _, path := range args[1:] {
+
+		out, err := deliver(path, maildir)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			ret = subcommands.ExitFailure
+			continue
+		}
+		fmt.Println(out)
+	}
+
+	return ret
+}
@@ -0,0 +1,222 @@
+// Structured output, as JSON or CSV, for our listing sub-commands.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// structuredOutput holds the state of the output-flags shared by our
+// listing sub-commands.
+type structuredOutput struct {
+
+	// Output a JSON array.
+	json bool
+
+	// Output one JSON object per line.
+	jsonl bool
+
+	// Output CSV, with a header-row.
+	csv bool
+
+	// The headers to include in records, comma-separated, or empty
+	// for all of them.
+	headers string
+}
+
+// SetFlags registers our flags.
+//
+// The -headers flag is only registered if the records contain headers.
+func (o *structuredOutput) SetFlags(f *flag.FlagSet, headers bool) {
+	f.BoolVar(&o.json, "json", false, "Output the records as a JSON array.")
+	f.BoolVar(&o.jsonl, "jsonl", false, "Output the records as JSON, one object per line.")
+	f.BoolVar(&o.csv, "csv", false, "Output the records as CSV, with a header-row.")
+	if headers {
+		f.StringVar(&o.headers, "headers", "", "The headers to include in structured output, comma-separated, rather than all of them.")
+	}
+}
+
+// enabled returns true if structured output was requested.
+func (o *structuredOutput) enabled() bool {
+	return o.json || o.jsonl || o.csv
+}
+
+// validate returns an error if more than one output-mode was requested.
+func (o *structuredOutput) validate() error {
+
+	count := 0
+	for _, mode := range []bool{o.json, o.jsonl, o.csv} {
+		if mode {
+			count++
+		}
+	}
+	if count > 1 {
+		return fmt.Errorf("only one of -json, -jsonl, or -csv may be used")
+	}
+	return nil
+}
+
+// headerNames returns the (canonical) names of the headers to include
+// for the given messages, which are those requested or the names of all
+// the headers present in any of them.
+func (o *structuredOutput) headerNames(emails []*mailreader.Email) []string {
+
+	if o.headers != "" {
+		var names []string
+		for _, name := range strings.Split(o.headers, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, textproto.CanonicalMIMEHeaderKey(name))
+			}
+		}
+		return names
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, email := range emails {
+		for _, name := range email.HeaderNames() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// headerValues returns the (decoded) values of the given headers from
+// the message.  Every value of headers which appear more than once, such
+// as "Received", is included.
+//
+// Headers which are missing are omitted, unless they were explicitly
+// requested in which case they're present but empty.
+func (o *structuredOutput) headerValues(email *mailreader.Email, names []string) map[string][]string {
+
+	values := make(map[string][]string)
+	for _, name := range names {
+		value := email.HeaderValues(name)
+		if len(value) > 0 {
+			values[name] = value
+		} else if o.headers != "" {
+			values[name] = []string{}
+		}
+	}
+	return values
+}
+
+// headerCell returns the values of a header as a single CSV cell, with
+// one value per line.
+func headerCell(values []string) string {
+	return strings.Join(values, "\n")
+}
+
+// write outputs the given records, in our configured format.
+//
+// JSON output uses the records directly, while CSV output uses the given
+// column-names and rows.
+func (o *structuredOutput) write(records []interface{}, columns []string, rows [][]string) error {
+	return o.writeTo(os.Stdout, records, columns, rows)
+}
+
+// writeTo outputs the given records to the specified writer.
+func (o *structuredOutput) writeTo(out io.Writer, records []interface{}, columns []string, rows [][]string) error {
+
+	switch {
+	case o.json:
+		if records == nil {
+			records = []interface{}{}
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+
+	case o.jsonl:
+		encoder := json.NewEncoder(out)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case o.csv:
+		writer := csv.NewWriter(out)
+		writer.Write(columns)
+		writer.WriteAll(rows)
+		return writer.Error()
+	}
+
+	return nil
+}
+
+// messageSize returns the size of the given message-file, or zero if it
+// can't be found.
+func messageSize(path string) int64 {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// MaildirRecord is the structured representation of a maildir.
+type MaildirRecord struct {
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Total   int    `json:"total"`
+	Unread  int    `json:"unread"`
+	New     int    `json:"new"`
+	Flagged int    `json:"flagged"`
+	Replied int    `json:"replied"`
+	Trashed int    `json:"trashed"`
+	Draft   int    `json:"draft"`
+}
+
+// MessageRecord is the structured representation of a message within
+// a listing.
+type MessageRecord struct {
+	Index   int                 `json:"index"`
+	Path    string              `json:"path"`
+	Maildir string              `json:"maildir"`
+	Folder  string              `json:"folder"`
+	Flags   string              `json:"flags"`
+	Size    int64               `json:"size"`
+	Thread  int                 `json:"thread"`
+	Depth   int                 `json:"depth"`
+	Headers map[string][]string `json:"headers"`
+}
+
+// MessageContent is the structured representation of a single message,
+// including its content.
+type MessageContent struct {
+	Path        string              `json:"path"`
+	Maildir     string              `json:"maildir"`
+	Flags       string              `json:"flags"`
+	Size        int64               `json:"size"`
+	Headers     map[string][]string `json:"headers"`
+	Body        string              `json:"body"`
+	BodyHTML    string              `json:"body_html"`
+	Attachments []AttachmentRecord  `json:"attachments"`
+}
+
+// AttachmentRecord is the structured representation of an attachment,
+// which omits its content.
+type AttachmentRecord struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id"`
+	Inline      bool   `json:"inline"`
+	Size        int    `json:"size"`
+}
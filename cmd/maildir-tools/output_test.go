@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// testEmails returns some messages, with headers but no files, for
+// building records.
+func testEmails() []*mailreader.Email {
+
+	return []*mailreader.Email{
+		mailreader.NewFromHeader("/tmp/inbox/cur/1.host:2,S", mail.Header{
+			"Subject":  []string{"Hello, \"world\""},
+			"Received": []string{"from a", "from b"},
+		}),
+		mailreader.NewFromHeader("/tmp/inbox/cur/2.host:2,", mail.Header{
+			"Subject": []string{"two\nlines"},
+			"From":    []string{"steve@example.com"},
+		}),
+	}
+}
+
+// testRecords returns the records, column-names, and rows, for the
+// given messages in the way our listing sub-commands build them.
+func testRecords(o *structuredOutput, emails []*mailreader.Email) ([]interface{}, []string, [][]string) {
+
+	headers := o.headerNames(emails)
+	columns := append([]string{"index", "path"}, headers...)
+
+	var records []interface{}
+	var rows [][]string
+	for i, email := range emails {
+		record := MessageRecord{Index: i + 1,
+			Path:    email.Filename,
+			Headers: o.headerValues(email, headers)}
+		records = append(records, record)
+
+		row := []string{fmt.Sprintf("%d", i+1), email.Filename}
+		for _, name := range headers {
+			row = append(row, headerCell(record.Headers[name]))
+		}
+		rows = append(rows, row)
+	}
+	return records, columns, rows
+}
+
+func TestValidate(t *testing.T) {
+
+	type TestCase struct {
+		Output structuredOutput
+		Valid  bool
+	}
+
+	tests := []TestCase{
+		{structuredOutput{}, true},
+		{structuredOutput{json: true}, true},
+		{structuredOutput{jsonl: true}, true},
+		{structuredOutput{csv: true, headers: "Subject"}, true},
+		{structuredOutput{json: true, jsonl: true}, false},
+		{structuredOutput{json: true, csv: true}, false},
+		{structuredOutput{jsonl: true, csv: true}, false},
+		{structuredOutput{json: true, jsonl: true, csv: true}, false},
+	}
+
+	for _, tst := range tests {
+		err := tst.Output.validate()
+		if tst.Valid && err != nil {
+			t.Errorf("%+v: unexpected error %s", tst.Output, err)
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("%+v: expected an error", tst.Output)
+		}
+	}
+}
+
+func TestHeaderNames(t *testing.T) {
+
+	type TestCase struct {
+		Headers string
+		Names   []string
+	}
+
+	tests := []TestCase{
+		{"", []string{"From", "Received", "Subject"}},
+		{"subject", []string{"Subject"}},
+		{"subject, x-missing,,", []string{"Subject", "X-Missing"}},
+	}
+
+	for _, tst := range tests {
+		o := structuredOutput{headers: tst.Headers}
+		names := o.headerNames(testEmails())
+		if !reflect.DeepEqual(names, tst.Names) {
+			t.Errorf("%q: expected %v, got %v", tst.Headers, tst.Names, names)
+		}
+	}
+}
+
+func TestHeaderValues(t *testing.T) {
+
+	type TestCase struct {
+		Headers string
+		Values  map[string][]string
+	}
+
+	tests := []TestCase{
+		// Every value of a repeated header.
+		{"", map[string][]string{
+			"Received": {"from a", "from b"},
+			"Subject":  {"Hello, \"world\""}}},
+
+		// Requested headers are present, even if missing.
+		{"Subject,From", map[string][]string{
+			"Subject": {"Hello, \"world\""},
+			"From":    {}}},
+	}
+
+	for _, tst := range tests {
+		o := structuredOutput{headers: tst.Headers}
+		emails := testEmails()
+		values := o.headerValues(emails[0], o.headerNames(emails))
+		if !reflect.DeepEqual(values, tst.Values) {
+			t.Errorf("%q: expected %v, got %v", tst.Headers, tst.Values, values)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+
+	type TestCase struct {
+		Output structuredOutput
+		Result string
+	}
+
+	tests := []TestCase{
+		{structuredOutput{jsonl: true, headers: "Received,X-Missing"},
+			`{"index":1,"path":"/tmp/inbox/cur/1.host:2,S","maildir":"","folder":"","flags":"","size":0,"thread":0,"depth":0,"headers":{"Received":["from a","from b"],"X-Missing":[]}}
+{"index":2,"path":"/tmp/inbox/cur/2.host:2,","maildir":"","folder":"","flags":"","size":0,"thread":0,"depth":0,"headers":{"Received":[],"X-Missing":[]}}
+`},
+		{structuredOutput{csv: true},
+			`index,path,From,Received,Subject
+1,"/tmp/inbox/cur/1.host:2,S",,"from a
+from b","Hello, ""world"""
+2,"/tmp/inbox/cur/2.host:2,",steve@example.com,,"two
+lines"
+`},
+		{structuredOutput{csv: true, headers: "X-Missing"},
+			`index,path,X-Missing
+1,"/tmp/inbox/cur/1.host:2,S",
+2,"/tmp/inbox/cur/2.host:2,",
+`},
+	}
+
+	for _, tst := range tests {
+
+		records, columns, rows := testRecords(&tst.Output, testEmails())
+
+		var out bytes.Buffer
+		if err := tst.Output.writeTo(&out, records, columns, rows); err != nil {
+			t.Fatalf("%+v: unexpected error %s", tst.Output, err)
+		}
+		if out.String() != tst.Result {
+			t.Errorf("%+v: expected\n%s\ngot\n%s", tst.Output, tst.Result, out.String())
+		}
+	}
+}
+
+func TestWriteToJSON(t *testing.T) {
+
+	o := structuredOutput{json: true}
+	records, columns, rows := testRecords(&o, testEmails())
+
+	var out bytes.Buffer
+	if err := o.writeTo(&out, records, columns, rows); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	// The output is a single array of records.
+	var parsed []MessageRecord
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatalf("output isn't a JSON array: %s\n%s", err, out.String())
+	}
+	if len(parsed) != 2 || parsed[0].Index != 1 || parsed[1].Index != 2 {
+		t.Fatalf("unexpected records: %+v", parsed)
+	}
+	if !reflect.DeepEqual(parsed[0].Headers["Received"], []string{"from a", "from b"}) {
+		t.Errorf("missing repeated header values: %v", parsed[0].Headers)
+	}
+
+	// Headers missing from a message are omitted, unless requested.
+	if _, ok := parsed[1].Headers["Received"]; ok {
+		t.Errorf("unexpected header in record: %v", parsed[1].Headers)
+	}
+
+	// No records is still an array.
+	out.Reset()
+	if err := o.writeTo(&out, nil, columns, nil); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("expected an empty array, got %q", out.String())
+	}
+}
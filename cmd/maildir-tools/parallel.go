@@ -0,0 +1,54 @@
+// Helpers for running work in parallel.
+
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallel invokes the given function once for each index in the range
+// [0, count), using a pool of `jobs` workers.
+//
+// If jobs is less than one we launch one worker per CPU.
+//
+// The function will be called from multiple goroutines, in an undefined
+// order, so callers should store their results by index to ensure that
+// their output is deterministic.
+func parallel(count int, jobs int, fn func(index int)) {
+
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > count {
+		jobs = count
+	}
+
+	// No point in the overhead of goroutines for a single worker.
+	if jobs <= 1 {
+		for i := 0; i < count; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
@@ -0,0 +1,210 @@
+// Search for messages across maildirs.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/cache"
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/formatter"
+	"github.com/skx/maildir-tools/mailreader"
+	"github.com/skx/maildir-tools/query"
+)
+
+// searchCmd holds our state
+type searchCmd struct {
+
+	// The prefix to our maildir hierarchy
+	prefix string
+
+	// The separator to use within logical folder-names.
+	separator string
+
+	// The format-string to use for displaying results
+	format string
+
+	// The single folder to search, if any.
+	folder string
+
+	// The directory to cache message-headers within, if any.
+	cache string
+
+	// The number of messages to search in parallel.
+	jobs int
+}
+
+//
+// Glue
+//
+func (*searchCmd) Name() string     { return "search" }
+func (*searchCmd) Synopsis() string { return "Search for messages." }
+func (*searchCmd) Usage() string {
+	return `search [-folder name] query :
+  Search for messages matching the given query, in all maildirs beneath
+ the prefix, or just the one specified via '-folder'.
+
+  Queries are made up of terms, which are implicitly joined with AND:
+
+    word            - Matches the Subject, From, To, or Cc headers.
+    from:steve      - Matches the From header, and similarly for
+                      to:, cc:, bcc:, and subject:.
+    body:invoice    - Matches the body of the message.
+    x-mailer:mutt   - Matches any other named header.
+    flag:FS         - Matches messages with all of the given flags.
+    is:unread       - Matches messages by state: unread, new, read, seen,
+                      flagged, replied, passed, trashed, or draft.
+    date:2020-01    - Matches messages sent within the given day, month,
+                      or year.
+    date:A..B       - Matches messages sent between the two dates, either
+                      of which may be omitted.
+    before:DATE     - Matches messages sent before the given date.
+    after:DATE      - Matches messages sent after the given date.
+
+  Text-matches are case-insensitive, unless the value is surrounded by
+ slashes in which case it is a regular expression.  Values with spaces
+ in them may be quoted.  Terms may be combined with AND, OR, and NOT,
+ grouped with parentheses, and negated with a leading '-'.  For example:
+
+    maildir-tools search 'from:steve (subject:"weekly report" OR body:invoice) -is:read'
+`
+}
+
+//
+// Flag setup
+//
+func (p *searchCmd) SetFlags(f *flag.FlagSet) {
+	prefix := os.Getenv("HOME") + "/Maildir/"
+
+	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
+	f.StringVar(&p.format, "format", "#{file}", "Specify the format-string to use for the results.")
+	f.StringVar(&p.folder, "folder", "", "Only search the given folder.")
+	f.StringVar(&p.cache, "cache", cache.DefaultDirectory(), "The directory to cache message-headers in, set to empty to disable.")
+	f.IntVar(&p.jobs, "jobs", runtime.NumCPU(), "The number of messages to search in parallel.")
+}
+
+var (
+	// queryField matches a term with a field-name, and the value
+	// of a term after any negation or field-name.
+	queryField = regexp.MustCompile("^[A-Za-z0-9_-]+:")
+	queryValue = regexp.MustCompile("^(-?(?:[A-Za-z0-9_-]+:)?)(.*)$")
+)
+
+// queryString rebuilds our query from the given arguments.
+//
+// The shell removes the quotes from arguments such as
+// subject:"weekly report", so an argument containing spaces which is
+// otherwise a single term has its value quoted again.  Arguments which
+// contain query-syntax, such as a whole query, are used as-is.
+func queryString(args []string) string {
+
+	var terms []string
+	for _, arg := range args {
+		terms = append(terms, requote(strings.TrimSpace(arg)))
+	}
+	return strings.Join(terms, " ")
+}
+
+// requote quotes the value of the given argument, if it is a single
+// term containing spaces.
+func requote(arg string) string {
+
+	words := strings.Fields(arg)
+	if len(words) < 2 || strings.ContainsAny(arg, "\"()") {
+		return arg
+	}
+
+	// Later words which look like terms, or operators, mean this
+	// is a query rather than a value.
+	for _, word := range words[1:] {
+		switch {
+		case word == "AND" || word == "OR" || word == "NOT":
+			return arg
+		case strings.HasPrefix(word, "-") || queryField.MatchString(word):
+			return arg
+		}
+	}
+
+	m := queryValue.FindStringSubmatch(arg)
+	return m[1] + "\"" + m[2] + "\""
+}
+
+// Search returns the messages matching the given query.
+//
+// A folder which can't be read doesn't stop us searching the others, it
+// is reported on STDERR and skipped.  In that case the matches from the
+// remaining folders are returned, along with an error.
+func (p *searchCmd) Search(q *query.Query) ([]*mailreader.Email, error) {
+
+	helper := &messagesCmd{prefix: p.prefix, separator: p.separator, cache: p.cache, jobs: p.jobs}
+
+	folders := []string{p.folder}
+	if p.folder == "" {
+		folders = finder.New(p.prefix).Maildirs()
+	}
+
+	var results []*mailreader.Email
+
+	failed := 0
+
+	for _, folder := range folders {
+
+		emails, err := helper.GetEmails(folder)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to search %s - %s\n", folder, err.Error())
+			failed++
+			continue
+		}
+
+		// Test each message in parallel, because body-searches
+		// require each message to be parsed.
+		matched := make([]bool, len(emails))
+		parallel(len(emails), p.jobs, func(index int) {
+			matched[index] = q.Match(emails[index])
+		})
+
+		for i, email := range emails {
+			if matched[i] {
+				results = append(results, email)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("failed to search %d folder(s)", failed)
+	}
+	return results, nil
+}
+
+//
+// Entry-point.
+//
+func (p *searchCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	q, err := query.Parse(queryString(f.Args()))
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitUsageError
+	}
+
+	// Show the results we found, even if some folders failed.
+	results, err := p.Search(q)
+
+	tmpl := formatter.Compile(p.format)
+	for index, mail := range results {
+		fmt.Println(tmpl.Render(messageMapper(mail, index, len(results))))
+	}
+
+	if err != nil {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/skx/maildir-tools/mailreader"
+	"github.com/skx/maildir-tools/query"
+)
+
+func TestQueryString(t *testing.T) {
+
+	type TestCase struct {
+		Args   []string
+		Output string
+	}
+
+	tests := []TestCase{
+		// A whole query, as one argument, is used as-is.
+		{[]string{`subject:"weekly report"`}, `subject:"weekly report"`},
+		{[]string{`from:steve (subject:"weekly report" OR body:invoice) -is:read`},
+			`from:steve (subject:"weekly report" OR body:invoice) -is:read`},
+		{[]string{"from:steve -is:read"}, "from:steve -is:read"},
+		{[]string{"from:steve OR from:bob"}, "from:steve OR from:bob"},
+
+		// Arguments which the shell unquoted are quoted again.
+		{[]string{"subject:weekly report"}, `subject:"weekly report"`},
+		{[]string{"from:steve", "subject:weekly report"}, `from:steve subject:"weekly report"`},
+		{[]string{"-subject:weekly report"}, `-subject:"weekly report"`},
+		{[]string{"weekly report"}, `"weekly report"`},
+		{[]string{"body:/invoice #[0-9]+/"}, `body:"/invoice #[0-9]+/"`},
+
+		// Separate words are separate terms.
+		{[]string{"weekly", "report"}, "weekly report"},
+		{[]string{"from:steve", "-is:read"}, "from:steve -is:read"},
+	}
+
+	for _, tst := range tests {
+		out := queryString(tst.Args)
+		if out != tst.Output {
+			t.Errorf("queryString(%q): expected %s, got %s", tst.Args, tst.Output, out)
+		}
+	}
+}
+
+func TestQueryStringMatch(t *testing.T) {
+
+	phrase := mailreader.NewFromHeader("1", mail.Header{"Subject": []string{"The weekly report"}})
+	words := mailreader.NewFromHeader("2", mail.Header{"Subject": []string{"A report, weekly"}})
+
+	// Both of these find the phrase, and only the phrase.
+	for _, args := range [][]string{
+		{`subject:"weekly report"`},
+		{"subject:weekly report"},
+	} {
+		q, err := query.Parse(queryString(args))
+		if err != nil {
+			t.Fatalf("%q: failed to parse: %s", args, err)
+		}
+		if !q.Match(phrase) || q.Match(words) {
+			t.Errorf("%q: expected to match only the phrase", args)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Deleting messages in the ui, by moving them into a trash maildir or
+// marking them as trashed, along with undoing and expunging deletions.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rivo/tview"
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// errNothingToUndo is returned when there are no deletions to undo.
+var errNothingToUndo = errors.New("nothing to undo")
+
+// deletion records a message which was deleted, so that we can undo it.
+type deletion struct {
+
+	// path holds the current path of the deleted message.
+	path string
+
+	// maildir holds the maildir the message was moved from, if it was
+	// moved into the trash.  If this is empty the message was deleted
+	// by adding the trashed flag.
+	maildir string
+}
+
+// trashCan deletes messages, and keeps track of them so that the
+// deletions can be undone.
+type trashCan struct {
+
+	// maildir holds the absolute path of the maildir to move deleted
+	// messages into, if any.  If this is empty deleted messages are
+	// marked as trashed instead.
+	maildir string
+
+	// deleted holds the messages we've deleted, most recent last.
+	deleted []deletion
+}
+
+// isTrash returns true if the given maildir is our trash maildir.
+func (t *trashCan) isTrash(maildir string) bool {
+
+	if t.maildir == "" {
+		return false
+	}
+
+	// Our trash is stored as an absolute path.
+	abs, err := filepath.Abs(maildir)
+	return err == nil && sameMaildir(abs, t.maildir)
+}
+
+// removes returns true if deleting the given message-file will remove
+// it from its maildir, rather than marking it as trashed.
+func (t *trashCan) removes(path string) bool {
+	return t.maildir != "" && !t.isTrash(filepath.Dir(filepath.Dir(path)))
+}
+
+// delete deletes the given message, recording how to undo it.
+//
+// If we have a trash maildir the message is moved into it, otherwise, or
+// if it is already there, it is marked as trashed.  The new path of the
+// message is returned.
+func (t *trashCan) delete(path string) (string, error) {
+
+	if t.removes(path) {
+		dst, err := mailreader.Move(path, t.maildir)
+		if err != nil {
+			return path, err
+		}
+		t.deleted = append(t.deleted, deletion{path: dst, maildir: filepath.Dir(filepath.Dir(path))})
+		return dst, nil
+	}
+
+	// Deleting a message twice shouldn't record anything to undo.
+	if finder.ParseState(path).Trashed() {
+		return path, nil
+	}
+
+	dst, err := mailreader.ChangeFlags(path, "T", "")
+	if err != nil {
+		return path, err
+	}
+	t.deleted = append(t.deleted, deletion{path: dst})
+	return dst, nil
+}
+
+// undo restores the message which was most recently deleted, either by
+// moving it back out of the trash or by removing its trashed flag.
+//
+// The path of the message before, and after, it was restored is returned.
+func (t *trashCan) undo() (string, string, error) {
+
+	if len(t.deleted) == 0 {
+		return "", "", errNothingToUndo
+	}
+
+	last := t.deleted[len(t.deleted)-1]
+
+	var dst string
+	var err error
+	if last.maildir != "" {
+		dst, err = mailreader.Move(last.path, last.maildir)
+	} else {
+		dst, err = mailreader.ChangeFlags(last.path, "", "T")
+	}
+	if err != nil {
+		return last.path, last.path, err
+	}
+
+	t.deleted = t.deleted[:len(t.deleted)-1]
+	return last.path, dst, nil
+}
+
+// rename records that a deleted message has been renamed, such as when
+// it is marked as read, so that we can still undo its deletion.
+func (t *trashCan) rename(old string, path string) {
+
+	for i, d := range t.deleted {
+		if d.path == old {
+			t.deleted[i].path = path
+		}
+	}
+}
+
+// expunged returns the message-files, from those in the given maildir,
+// which would be removed by expunging it: those marked as trashed, or all
+// of them if it is our trash maildir.
+func (t *trashCan) expunged(maildir string, paths []string) []string {
+
+	trash := t.isTrash(maildir)
+
+	var ret []string
+	for _, path := range paths {
+		if trash || finder.ParseState(path).Trashed() {
+			ret = append(ret, path)
+		}
+	}
+	return ret
+}
+
+// purge permanently removes the given message-files, returning the number
+// removed and the last error, if any.
+//
+// Deletions of the messages we remove can no longer be undone, so they
+// are forgotten.
+func (t *trashCan) purge(paths []string) (int, error) {
+
+	var failed error
+
+	removed := make(map[string]bool)
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			failed = err
+			continue
+		}
+		removed[path] = true
+	}
+
+	var deleted []deletion
+	for _, d := range t.deleted {
+		if !removed[d.path] {
+			deleted = append(deleted, d)
+		}
+	}
+	t.deleted = deleted
+
+	return len(removed), failed
+}
+
+// deleteSelectedMessage deletes the message under the point, in
+// the list of messages.
+//
+// You can delete a message as it is being viewed, via the
+// DeleteCurrentMessage function.
+func (p *uiCmd) deleteSelectedMessage() {
+
+	selected := p.messageList.GetCurrentItem()
+	if selected < 0 || selected >= len(p.messages) {
+		return
+	}
+
+	// Moving the message into the trash removes it from the list.
+	path := p.messages[selected].Path
+	if p.trash.removes(path) {
+		p.removeSelectedMessage(func(path string) error {
+			_, err := p.trash.delete(path)
+			return err
+		})
+		return
+	}
+
+	dst, err := p.trash.delete(path)
+	if err != nil {
+		p.notify(err.Error())
+		return
+	}
+	p.renameMessage(path, dst)
+	p.refreshMessages()
+
+	// Move onto the next message, as it would if we'd removed it.
+	if selected+1 < len(p.messages) {
+		p.messageList.SetCurrentItem(selected + 1)
+	}
+}
+
+// DeleteCurrentMessage is the function that deletes the currently
+// being viewed message, and moves onto the next if possible.
+//
+// This is distinct from deleting messages while viewing the
+// message-list in the `messages`-mode.
+func (p *uiCmd) DeleteCurrentMessage() {
+
+	selected := p.messageList.GetCurrentItem()
+	if selected < 0 || selected >= len(p.messages) {
+		return
+	}
+
+	// Moving the message into the trash removes it from the list.
+	path := p.messages[selected].Path
+	if p.trash.removes(path) {
+		p.removeCurrentMessage(func(path string) error {
+			_, err := p.trash.delete(path)
+			return err
+		})
+		return
+	}
+
+	dst, err := p.trash.delete(path)
+	if err != nil {
+		p.notify(err.Error())
+		return
+	}
+	p.renameMessage(path, dst)
+	p.refreshMessages()
+	p.NextMessage()
+}
+
+// UndoDelete restores the message which was most recently deleted.
+func (p *uiCmd) UndoDelete() {
+
+	old, dst, err := p.trash.undo()
+	if err == errNothingToUndo {
+		p.notify("Nothing to undo")
+		return
+	}
+	if err != nil {
+		p.notify(err.Error())
+		return
+	}
+
+	p.renameMessage(old, dst)
+	p.refreshMessages()
+
+	// Select the restored message, if it is in the list.
+	if p.mode() == "messages" {
+		for i, msg := range p.messages {
+			if msg.Path == dst {
+				p.messageList.SetCurrentItem(i)
+				p.curEmail = dst
+			}
+		}
+	}
+
+	p.notify("Restored deleted message")
+}
+
+// Expunge permanently removes the trashed messages in the current maildir,
+// or all the messages if the current maildir is our trash maildir.
+//
+// If more than one message would be removed the user is asked to confirm
+// it first.
+func (p *uiCmd) Expunge() {
+
+	var paths []string
+	for _, msg := range p.allMessages {
+		paths = append(paths, msg.Path)
+	}
+
+	purge := p.trash.expunged(p.curMaildir, paths)
+
+	if len(purge) == 0 {
+		p.notify("No messages to expunge")
+		return
+	}
+	if len(purge) == 1 {
+		p.purge(purge)
+		return
+	}
+
+	// Get the old layout which was shown
+	old := p.root
+
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Permanently delete %d messages?", len(purge))).
+		AddButtons([]string{"Delete", "Cancel"}).
+		SetDoneFunc(func(index int, label string) {
+
+			// Restore the old widget
+			p.app.SetRoot(old, true)
+
+			if label == "Delete" {
+				p.purge(purge)
+			}
+		})
+
+	// Default to the safe choice.
+	modal.SetFocus(1)
+	p.app.SetRoot(modal, true)
+}
+
+// purge removes the given message-files, and updates our display.
+func (p *uiCmd) purge(paths []string) {
+
+	count, err := p.trash.purge(paths)
+
+	p.refreshMessages()
+
+	if err != nil {
+		p.notify(err.Error())
+		return
+	}
+	p.notify(fmt.Sprintf("Expunged %d message(s)", count))
+}
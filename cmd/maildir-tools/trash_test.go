@@ -0,0 +1,238 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// makeMaildir creates an empty maildir beneath the given directory.
+func makeMaildir(t *testing.T, dir string) string {
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("failed to create maildir: %s", err)
+		}
+	}
+	return dir
+}
+
+// makeMessage writes a message into the cur/ directory of a maildir.
+func makeMessage(t *testing.T, maildir string, name string) string {
+
+	path := filepath.Join(maildir, "cur", name)
+	if err := ioutil.WriteFile(path, []byte("Subject: test\n\nBody\n"), 0644); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+	return path
+}
+
+// exists returns true if the given file exists.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestTrashFlag(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbox := makeMaildir(t, filepath.Join(dir, "inbox"))
+	path := makeMessage(t, inbox, "1.host:2,S")
+
+	// Without a trash maildir messages are flagged.
+	var trash trashCan
+	if trash.removes(path) {
+		t.Fatalf("deleting shouldn't remove the message")
+	}
+
+	dst, err := trash.delete(path)
+	if err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if dst != filepath.Join(inbox, "cur", "1.host:2,ST") || !exists(dst) {
+		t.Fatalf("unexpected path after deleting: %s", dst)
+	}
+
+	// Deleting it again does nothing, and records nothing.
+	again, err := trash.delete(dst)
+	if err != nil {
+		t.Fatalf("failed to delete twice: %s", err)
+	}
+	if again != dst || len(trash.deleted) != 1 {
+		t.Fatalf("deleting twice changed things: %s %+v", again, trash.deleted)
+	}
+
+	// Undoing removes the flag.
+	old, restored, err := trash.undo()
+	if err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	if old != dst || restored != path || !exists(path) {
+		t.Fatalf("unexpected paths after undo: %s -> %s", old, restored)
+	}
+
+	// There's nothing left to undo.
+	if _, _, err = trash.undo(); err != errNothingToUndo {
+		t.Fatalf("expected nothing to undo, got %v", err)
+	}
+}
+
+func TestTrashMaildir(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbox := makeMaildir(t, filepath.Join(dir, "inbox"))
+	bin := makeMaildir(t, filepath.Join(dir, "trash"))
+	path := makeMessage(t, inbox, "1.host:2,S")
+
+	// With a trash maildir messages are moved there.
+	trash := trashCan{maildir: bin}
+	if !trash.removes(path) {
+		t.Fatalf("deleting should remove the message")
+	}
+
+	dst, err := trash.delete(path)
+	if err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if exists(path) || filepath.Dir(filepath.Dir(dst)) != bin || !exists(dst) {
+		t.Fatalf("message wasn't moved into the trash: %s", dst)
+	}
+
+	// Deleting it from the trash flags it.
+	if trash.removes(dst) {
+		t.Fatalf("deleting from the trash shouldn't remove the message")
+	}
+	flagged, err := trash.delete(dst)
+	if err != nil {
+		t.Fatalf("failed to delete from the trash: %s", err)
+	}
+	if flagged != dst+"T" || len(trash.deleted) != 2 {
+		t.Fatalf("unexpected result deleting from the trash: %s %+v", flagged, trash.deleted)
+	}
+
+	// Undoing that removes the flag.
+	if _, restored, err := trash.undo(); err != nil || restored != dst {
+		t.Fatalf("failed to undo flagging: %s %v", restored, err)
+	}
+
+	// Undoing the first deletion moves it back, keeping its flags.
+	old, restored, err := trash.undo()
+	if err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	if old != dst || exists(dst) || filepath.Dir(filepath.Dir(restored)) != inbox {
+		t.Fatalf("message wasn't restored: %s -> %s", old, restored)
+	}
+	if !strings.HasSuffix(restored, ":2,S") {
+		t.Fatalf("message lost its flags: %s", restored)
+	}
+}
+
+func TestTrashRename(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbox := makeMaildir(t, filepath.Join(dir, "inbox"))
+	path := makeMessage(t, inbox, "1.host:2,")
+
+	var trash trashCan
+	dst, err := trash.delete(path)
+	if err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+
+	// Reading the deleted message marks it as seen, renaming it.
+	seen, err := mailreader.ChangeFlags(dst, "S", "")
+	if err != nil {
+		t.Fatalf("failed to mark as seen: %s", err)
+	}
+	trash.rename(dst, seen)
+
+	// Undoing the deletion finds it by its new name.
+	old, restored, err := trash.undo()
+	if err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	if old != seen || restored != filepath.Join(inbox, "cur", "1.host:2,S") || !exists(restored) {
+		t.Fatalf("unexpected paths after undo: %s -> %s", old, restored)
+	}
+}
+
+func TestExpunged(t *testing.T) {
+
+	trash := trashCan{maildir: "/tmp/trash"}
+
+	paths := []string{
+		"/tmp/inbox/cur/1.host:2,S",
+		"/tmp/inbox/cur/2.host:2,ST",
+		"/tmp/inbox/new/3.host",
+		"/tmp/inbox/cur/4.host:2,FT",
+	}
+
+	out := trash.expunged("/tmp/inbox", paths)
+	if len(out) != 2 || out[0] != paths[1] || out[1] != paths[3] {
+		t.Errorf("unexpected messages to expunge: %v", out)
+	}
+
+	// Everything in the trash is expunged.
+	out = trash.expunged("/tmp/trash/", paths)
+	if len(out) != len(paths) {
+		t.Errorf("expected to expunge everything in the trash, got %v", out)
+	}
+}
+
+func TestPurge(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbox := makeMaildir(t, filepath.Join(dir, "inbox"))
+	one := makeMessage(t, inbox, "1.host:2,S")
+	two := makeMessage(t, inbox, "2.host:2,S")
+
+	var trash trashCan
+	one, _ = trash.delete(one)
+	two, _ = trash.delete(two)
+
+	// Purging one message forgets its deletion, but not the other.
+	count, err := trash.purge([]string{one})
+	if err != nil || count != 1 {
+		t.Fatalf("failed to purge: %d %v", count, err)
+	}
+	if exists(one) {
+		t.Fatalf("purged message still exists")
+	}
+	if len(trash.deleted) != 1 || trash.deleted[0].path != two {
+		t.Fatalf("unexpected deletions after purge: %+v", trash.deleted)
+	}
+
+	// Purging a missing file is an error.
+	count, err = trash.purge([]string{one, two})
+	if err == nil || count != 1 {
+		t.Fatalf("expected an error purging a missing file: %d %v", count, err)
+	}
+	if len(trash.deleted) != 0 {
+		t.Fatalf("unexpected deletions after purge: %+v", trash.deleted)
+	}
+}
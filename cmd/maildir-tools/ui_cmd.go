@@ -19,12 +19,22 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell"
 	"github.com/google/subcommands"
 	"github.com/rivo/tview"
+	"github.com/skx/maildir-tools/cache"
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/formatter"
+	"github.com/skx/maildir-tools/mailreader"
+	"github.com/skx/maildir-tools/sorter"
+	"github.com/skx/maildir-tools/watcher"
 )
 
 // UIHistory stores UI history.
@@ -45,6 +55,15 @@ type UIHistory struct {
 	offset int
 }
 
+// init registers the "escape" filter, which lets format-strings show
+// text containing square-brackets without tview treating them as colour
+// tags.
+func init() {
+	formatter.RegisterFilter("escape", func(value string, _ string) string {
+		return tview.Escape(value)
+	})
+}
+
 // uiCmd holds the state of our TUI application.
 type uiCmd struct {
 
@@ -73,6 +92,18 @@ type uiCmd struct {
 	// List for displaying maildir-entries.
 	maildirList *tview.List
 
+	// Should the maildirs be shown as a tree?
+	tree bool
+
+	// The folders shown in the maildir-list, when showing a tree.
+	folders []*folderNode
+
+	// The folders in the tree which are expanded, by logical name.
+	expanded map[string]bool
+
+	// The file to record the expanded folders within, if any.
+	stateFile string
+
 	// Currently selected maildir.
 	//
 	// TODO: Do we need this?  `maildirList` is global so we can
@@ -87,6 +118,24 @@ type uiCmd struct {
 	// which are being displayed.
 	messages []SingleMessage
 
+	// All the messages in the currently-selected maildir, including
+	// those hidden within collapsed threads.
+	allMessages []SingleMessage
+
+	// Should the message-list be grouped into threads?
+	threaded bool
+
+	// The threads which have been collapsed, indexed by the path of
+	// the first message in each.
+	collapsed map[string]bool
+
+	// The order to sort messages in, by default.
+	sort string
+
+	// The order to sort messages in, for each maildir which has been
+	// changed from the default.
+	sorts map[string]string
+
 	// Path to current message
 	//
 	// TODO: Do we need this?  `messageList` is global so we can
@@ -100,13 +149,37 @@ type uiCmd struct {
 	// List for displaying help
 	helpList *tview.List
 
+	// The status-line, shown beneath each list.
+	status *tview.TextView
+
+	// The timer which clears the status-line, if any.
+	statusTimer *time.Timer
+
+	// The layout currently shown, holding a list and our status-line.
+	root tview.Primitive
+
+	// The watcher which tells us about changes to our maildirs.
+	watcher *watcher.Watcher
+
 	// Prefix for our maildir hierarchy
 	prefix string
+
+	// The separator to use within logical folder-names.
+	separator string
+
+	// Directory to cache message-headers within, if any.
+	cache string
+
+	// The messages we've deleted, and where they went.
+	trash trashCan
+
+	// The number of maildirs, or messages, to process in parallel.
+	jobs int
 }
 
 // getMaildirs returns ALL maildirs beneath our configured prefix-directory.
 func (p *uiCmd) getMaildirs() {
-	helper := &maildirsCmd{prefix: p.prefix, format: "#{unread_highlight}[#{06unread}/#{06total}] #{name}"}
+	helper := &maildirsCmd{prefix: p.prefix, cache: p.cache, jobs: p.jobs, separator: p.separator, format: "#{?unread>0:[red]}[#{06unread}/#{06total}] #{shortname|escape}"}
 	p.maildirs = helper.GetMaildirs()
 }
 
@@ -128,14 +201,295 @@ func (p *uiCmd) getMessages() {
 	p.messages = []SingleMessage{}
 
 	// Get the messages via our helper.
-	helper := &messagesCmd{}
-	p.messages, err = helper.GetMessages(p.curMaildir, "#{unread_highlight}[#{06index}/#{06total} [#{4flags}] #{subject}")
+	order, ok := p.sorts[p.curMaildir]
+	if !ok {
+		order = p.sort
+	}
+
+	helper := &messagesCmd{cache: p.cache, jobs: p.jobs, threaded: p.threaded, sort: order}
+	p.allMessages, err = helper.GetMessages(p.curMaildir, "#{?flags~N:[red]}[#{06index}/#{06total} [#{4flags}] #{thread_tree}#{subject|escape}")
 
 	// Failed to get messages?
 	if err != nil {
 		// TODO: Dialog
 		panic(err)
 	}
+
+	// Hide the contents of any collapsed threads.
+	p.messages = p.visibleMessages()
+}
+
+// visibleMessages returns the messages which should be displayed, which
+// is all of them except the replies within collapsed threads.
+//
+// The first message of a collapsed thread shows the number of messages
+// which are hidden.
+func (p *uiCmd) visibleMessages() []SingleMessage {
+
+	var visible []SingleMessage
+
+	for i := 0; i < len(p.allMessages); {
+
+		first := p.allMessages[i]
+
+		// Find the end of this thread.
+		end := i + 1
+		for end < len(p.allMessages) && p.allMessages[end].Thread == first.Thread {
+			end++
+		}
+
+		if p.collapsed[first.Path] && end-i > 1 {
+			first.Rendered += fmt.Sprintf(" (+%d)", end-i-1)
+			visible = append(visible, first)
+		} else {
+			visible = append(visible, p.allMessages[i:end]...)
+		}
+
+		i = end
+	}
+
+	return visible
+}
+
+// showMaildirs populates the maildir-list, either as a flat list or
+// as a tree.
+func (p *uiCmd) showMaildirs() {
+
+	// Empty the list
+	p.maildirList.Clear()
+
+	if p.tree {
+		p.showFolderTree()
+		return
+	}
+
+	// Add each (rendered) maildir
+	for _, r := range p.maildirs {
+
+		rendered := r.Rendered
+		if strings.Contains(rendered, "people-") {
+			rendered = "[red]" + rendered
+		}
+		// When selected it will change mode
+		p.maildirList.AddItem(rendered, r.Path, 0,
+			func() {
+				p.SetMode("messages", true)
+			})
+	}
+}
+
+// showFolderTree populates the maildir-list with the visible folders
+// in our tree.
+//
+// Collapsed folders show the counts of all the messages beneath them.
+func (p *uiCmd) showFolderTree() {
+
+	p.folders = visibleFolders(buildFolderTree(p.maildirs), p.expanded)
+
+	tmpl := formatter.Compile("#{?unread>0:[red]}[#{06unread}/#{06total}] #{indent}#{marker} #{name}")
+
+	for _, folder := range p.folders {
+
+		unread, total := folder.unread, folder.total
+
+		marker := " "
+		if len(folder.children) > 0 {
+			marker = "-"
+			if !p.expanded[folder.key] {
+				marker = "+"
+				unread, total = folder.counts()
+			}
+		}
+
+		fields := map[string]string{
+			"unread": fmt.Sprintf("%d", unread),
+			"total":  fmt.Sprintf("%d", total),
+			"indent": strings.Repeat("  ", folder.depth),
+			"marker": marker,
+			"name":   tview.Escape(folder.name),
+		}
+		rendered := tmpl.Render(func(field string) string { return fields[field] })
+
+		// When selected it will change mode, unless there's
+		// no maildir here - in which case we toggle it instead.
+		path := folder.path
+		p.maildirList.AddItem(rendered, path, 0,
+			func() {
+				if path == "" {
+					p.ToggleFolder()
+				} else {
+					p.curMaildir = path
+					p.SetMode("messages", true)
+				}
+			})
+	}
+}
+
+// ExpandFolder expands, or collapses, the selected folder in the tree.
+func (p *uiCmd) ExpandFolder(expand bool) {
+
+	if !p.tree || len(p.folders) == 0 {
+		return
+	}
+
+	selected := p.maildirList.GetCurrentItem()
+	folder := p.folders[selected]
+	if len(folder.children) == 0 || p.expanded[folder.key] == expand {
+		return
+	}
+
+	if expand {
+		p.expanded[folder.key] = true
+	} else {
+		delete(p.expanded, folder.key)
+	}
+
+	// Failing to record the state isn't fatal.
+	saveExpanded(p.stateFile, p.expanded)
+
+	p.showMaildirs()
+	p.maildirList.SetCurrentItem(selected)
+}
+
+// ToggleFolder expands the selected folder in the tree, or collapses it
+// if it is already expanded.
+func (p *uiCmd) ToggleFolder() {
+
+	if !p.tree || len(p.folders) == 0 {
+		return
+	}
+
+	folder := p.folders[p.maildirList.GetCurrentItem()]
+	p.ExpandFolder(!p.expanded[folder.key])
+}
+
+// ToggleTree switches the maildir-list between the tree, and flat,
+// views.
+func (p *uiCmd) ToggleTree() {
+
+	p.tree = !p.tree
+	p.showMaildirs()
+
+	// Keep the same maildir selected, if we can.
+	for i := 0; i < p.maildirList.GetItemCount(); i++ {
+		if _, path := p.maildirList.GetItemText(i); path == p.curMaildir && path != "" {
+			p.maildirList.SetCurrentItem(i)
+		}
+	}
+}
+
+// showMessages populates the message-list from our visible messages.
+func (p *uiCmd) showMessages() {
+
+	// Empty the list
+	p.messageList.Clear()
+
+	// Add each (rendered) item
+	for _, r := range p.messages {
+
+		// When selected it will change mode
+		p.messageList.AddItem(r.Rendered, r.Path, 0,
+			func() {
+				p.SetMode("email", true)
+			})
+	}
+}
+
+// threadStart returns the index of the first visible message in the
+// thread containing the message at the given index.
+func (p *uiCmd) threadStart(index int) int {
+
+	for index > 0 && p.messages[index-1].Thread == p.messages[index].Thread {
+		index--
+	}
+	return index
+}
+
+// ToggleThread collapses the thread containing the selected message, or
+// expands it if it is already collapsed.
+func (p *uiCmd) ToggleThread() {
+
+	if len(p.messages) == 0 {
+		return
+	}
+
+	start := p.threadStart(p.messageList.GetCurrentItem())
+	path := p.messages[start].Path
+
+	if p.collapsed[path] {
+		delete(p.collapsed, path)
+	} else {
+		p.collapsed[path] = true
+	}
+
+	p.messages = p.visibleMessages()
+	p.showMessages()
+	p.messageList.SetCurrentItem(start)
+}
+
+// NextThread moves the selection to the first message of the next thread.
+func (p *uiCmd) NextThread() {
+
+	selected := p.messageList.GetCurrentItem()
+	for i := selected + 1; i < len(p.messages); i++ {
+		if p.messages[i].Thread != p.messages[selected].Thread {
+			p.messageList.SetCurrentItem(i)
+			return
+		}
+	}
+}
+
+// PrevThread moves the selection to the first message of the previous
+// thread.
+func (p *uiCmd) PrevThread() {
+
+	start := p.threadStart(p.messageList.GetCurrentItem())
+	if start > 0 {
+		p.messageList.SetCurrentItem(p.threadStart(start - 1))
+	}
+}
+
+// ToggleThreading switches the message-list between the threaded, and
+// flat, views.
+func (p *uiCmd) ToggleThreading() {
+	p.threaded = !p.threaded
+	p.reloadMessages()
+}
+
+// CycleSort changes the sort-order of the current maildir to the next
+// available order, or reverses the current order.
+func (p *uiCmd) CycleSort(reverse bool) {
+
+	order, ok := p.sorts[p.curMaildir]
+	if !ok {
+		order = p.sort
+	}
+
+	if reverse {
+		p.sorts[p.curMaildir] = sorter.Toggle(order)
+	} else {
+		p.sorts[p.curMaildir] = sorter.Next(order)
+	}
+
+	p.reloadMessages()
+}
+
+// reloadMessages reloads the message-list, keeping the same message
+// selected if it is still present.
+func (p *uiCmd) reloadMessages() {
+
+	path := ""
+	if len(p.messages) > 0 {
+		path = p.messages[p.messageList.GetCurrentItem()].Path
+	}
+
+	p.SetMode("messages", false)
+
+	for i, msg := range p.messages {
+		if msg.Path == path {
+			p.messageList.SetCurrentItem(i)
+		}
+	}
 }
 
 // getMessage returns the content of a single email.
@@ -154,17 +508,21 @@ func (p *uiCmd) getEmail() []string {
 	}
 
 	//
-	// TODO: If the message is new then mark it as having
-	// been read
+	// If the message is new then mark it as having been read.
 	//
-	//   x = Message.New( file)
-	//   if ( strings.Contains(x.Flags(), "N") ) {
-    //      -> This will rename the file:
-	//      newFile:= message.MarkRead()
-	//      p.curEmail = newFile
-	//      file = newFile
-	//    }
+	// This will rename the file, so we need to update our
+	// records of the path to point to the new location.
 	//
+	email := &mailreader.Email{Filename: file}
+	if strings.Contains(email.Flags(), "N") {
+
+		// If this fails we'll still show the message, it'll
+		// just remain unread.
+		if email.ChangeFlags("S", "") == nil {
+			p.renameMessage(file, email.Filename)
+			file = email.Filename
+		}
+	}
 
 	// Get the output
 	helper := &messageCmd{}
@@ -177,6 +535,233 @@ func (p *uiCmd) getEmail() []string {
 	return strings.Split(out, "\n")
 }
 
+// renameMessage updates our state after a message has been renamed on-disk,
+// for example because its flags were changed.
+func (p *uiCmd) renameMessage(old string, path string) {
+
+	if p.curEmail == old {
+		p.curEmail = path
+	}
+
+	if p.collapsed[old] {
+		delete(p.collapsed, old)
+		p.collapsed[path] = true
+	}
+
+	p.trash.rename(old, path)
+
+	for i, msg := range p.allMessages {
+		if msg.Path == old {
+			p.allMessages[i].Path = path
+		}
+	}
+
+	for i, msg := range p.messages {
+		if msg.Path == old {
+			p.messages[i].Path = path
+
+			// Update the list-entry too, so that the
+			// change-handler reports the new path.
+			if i < p.messageList.GetItemCount() {
+				p.messageList.SetItemText(i, msg.Rendered, path)
+			}
+		}
+	}
+}
+
+// show makes the given list our main view, with the status-line
+// beneath it.
+func (p *uiCmd) show(list *tview.List) {
+
+	p.root = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(p.status, 1, 0, false)
+
+	p.app.SetRoot(p.root, true)
+}
+
+// notify shows the given message in the status-line, for a few seconds.
+func (p *uiCmd) notify(msg string) {
+
+	p.status.SetText(msg)
+
+	if p.statusTimer != nil {
+		p.statusTimer.Stop()
+	}
+	p.statusTimer = time.AfterFunc(5*time.Second, func() {
+		p.app.QueueUpdateDraw(func() {
+			if p.status.GetText(false) == msg {
+				p.status.SetText("")
+			}
+		})
+	})
+}
+
+// stop halts everything which might update our display from another
+// goroutine, so that nothing is queued once the application has stopped.
+func (p *uiCmd) stop() {
+
+	if p.statusTimer != nil {
+		p.statusTimer.Stop()
+	}
+	if p.watcher != nil {
+		p.watcher.Close()
+	}
+}
+
+// mode returns the mode we're currently in.
+func (p *uiCmd) mode() string {
+
+	if len(p.modeHistory) > 0 {
+		return p.modeHistory[len(p.modeHistory)-1].mode
+	}
+	return "maildir"
+}
+
+// watch starts watching our maildirs for changes, if we're not already
+// doing so.
+//
+// Changes are handled in the main-loop, via QueueUpdateDraw, so that
+// we don't need to worry about locking.
+func (p *uiCmd) watch() {
+
+	if p.watcher != nil || len(p.maildirs) == 0 {
+		return
+	}
+
+	var paths []string
+	for _, maildir := range p.maildirs {
+		paths = append(paths, maildir.Path)
+	}
+
+	w, err := watcher.New(paths)
+	if err != nil {
+		p.notify("Failed to watch maildirs: " + err.Error())
+		return
+	}
+	p.watcher = w
+
+	go func() {
+		for events := range w.Events {
+			events := events
+			p.app.QueueUpdateDraw(func() {
+				p.refresh(events)
+			})
+		}
+	}()
+}
+
+// refresh updates our display after the given changes to our maildirs,
+// keeping the current selection.
+func (p *uiCmd) refresh(events []watcher.Event) {
+
+	// Count new mail, and see if the current maildir has changed.
+	arrived := make(map[string]int)
+	changed := false
+
+	for _, ev := range events {
+		if ev.Type == watcher.Arrive || ev.Type == watcher.Move {
+			arrived[ev.Maildir]++
+		}
+		if sameMaildir(ev.Maildir, p.curMaildir) || sameMaildir(ev.OldMaildir, p.curMaildir) {
+			changed = true
+		}
+	}
+
+	switch p.mode() {
+	case "maildir":
+		p.refreshMaildirs()
+	case "messages", "email":
+		if changed {
+			p.refreshMessages()
+		}
+	}
+
+	// Tell the user about new mail.
+	if len(arrived) > 0 {
+		var names []string
+		total := 0
+		for _, maildir := range p.maildirs {
+			if count := arrived[maildir.Path]; count > 0 {
+				names = append(names, maildir.Name)
+				total += count
+			}
+		}
+		if total > 0 {
+			p.notify(fmt.Sprintf("%d new message(s) in %s", total, strings.Join(names, ", ")))
+		}
+	}
+}
+
+// sameMaildir returns true if the two paths refer to the same maildir.
+func sameMaildir(a string, b string) bool {
+	return a != "" && b != "" && filepath.Clean(a) == filepath.Clean(b)
+}
+
+// refreshMaildirs reloads the maildir-list, keeping the same maildir
+// selected.
+func (p *uiCmd) refreshMaildirs() {
+
+	selected := p.maildirList.GetCurrentItem()
+	_, path := p.maildirList.GetItemText(selected)
+
+	p.getMaildirs()
+	p.showMaildirs()
+
+	for i := 0; i < p.maildirList.GetItemCount(); i++ {
+		if _, cur := p.maildirList.GetItemText(i); cur == path && path != "" {
+			selected = i
+		}
+	}
+
+	if selected >= p.maildirList.GetItemCount() {
+		selected = p.maildirList.GetItemCount() - 1
+	}
+	p.maildirList.SetCurrentItem(selected)
+
+	// The change-handler doesn't run if the offset is unchanged.
+	if selected >= 0 {
+		_, p.curMaildir = p.maildirList.GetItemText(selected)
+	}
+}
+
+// refreshMessages reloads the message-list, keeping the same message
+// selected, without changing the current view.
+func (p *uiCmd) refreshMessages() {
+
+	path := p.curEmail
+	selected := p.messageList.GetCurrentItem()
+	if selected < len(p.messages) {
+		path = p.messages[selected].Path
+	}
+
+	p.getMessages()
+	p.showMessages()
+
+	for i, msg := range p.messages {
+		if msg.Path == path {
+			selected = i
+		}
+	}
+
+	if selected >= len(p.messages) {
+		selected = len(p.messages) - 1
+	}
+	if selected < 0 {
+		return
+	}
+	p.messageList.SetCurrentItem(selected)
+
+	// The change-handler doesn't run if the offset is unchanged.
+	p.curEmail = p.messages[selected].Path
+
+	// If we're viewing a message then our history records the
+	// offset to return to.
+	if p.mode() == "email" {
+		p.modeHistory[len(p.modeHistory)-1].offset = selected
+	}
+}
+
 // SetMode updates our global state to be one of:
 //
 //    maildir | View a list of maildirs.
@@ -219,22 +804,8 @@ func (p *uiCmd) SetMode(mode string, record bool) {
 		// get the initial lines for the maildir view
 		p.getMaildirs()
 
-		// Empty the list
-		p.maildirList.Clear()
-
-		// Add each (rendered) maildir
-		for _, r := range p.maildirs {
-
-			rendered := r.Rendered
-			if strings.Contains(rendered, "people-") {
-				rendered = "[red]" + rendered
-			}
-			// When selected it will change mode
-			p.maildirList.AddItem(rendered, r.Path, 0,
-				func() {
-					p.SetMode("messages", true)
-				})
-		}
+		// Populate the list
+		p.showMaildirs()
 
 		// When the selection changes we update our current
 		// maildir folder.
@@ -243,7 +814,11 @@ func (p *uiCmd) SetMode(mode string, record bool) {
 		})
 
 		// Update UI
-		p.app.SetRoot(p.maildirList, true)
+		p.show(p.maildirList)
+
+		// Watch the maildirs for changes, the first time we
+		// have found them.
+		p.watch()
 		return
 	}
 
@@ -252,18 +827,8 @@ func (p *uiCmd) SetMode(mode string, record bool) {
 		// get the messages we want to display
 		p.getMessages()
 
-		// Empty the list
-		p.messageList.Clear()
-
-		// Add each (rendered) item
-		for _, r := range p.messages {
-
-			// When selected it will change mode
-			p.messageList.AddItem(r.Rendered, r.Path, 0,
-				func() {
-					p.SetMode("email", true)
-				})
-		}
+		// Populate the list
+		p.showMessages()
 
 		// When the selection changes we update our current
 		// maildir folder.
@@ -272,7 +837,7 @@ func (p *uiCmd) SetMode(mode string, record bool) {
 		})
 
 		// Update UI
-		p.app.SetRoot(p.messageList, true)
+		p.show(p.messageList)
 		return
 	}
 
@@ -290,7 +855,7 @@ func (p *uiCmd) SetMode(mode string, record bool) {
 		}
 
 		// Update UI
-		p.app.SetRoot(p.emailList, true)
+		p.show(p.emailList)
 		return
 	}
 
@@ -326,6 +891,10 @@ The maildir-list mode has the following additional keybindings:
   Key | Action
   ----+---------------------------------------------------------
     N | Move to the next maildir containing unread messages.
+    t | Toggle between the tree, and flat, views.
+    c | Expand, or collapse, the selected folder in the tree.
+ Left | Collapse the selected folder in the tree.
+Right | Expand the selected folder in the tree.
 
 
 The message-index mode has the following additional keybindings:
@@ -333,7 +902,16 @@ The message-index mode has the following additional keybindings:
   Key | Action
   ----+---------------------------------------------------------
     d | Delete the selected message.
+    u | Undo the last delete.
+    X | Expunge the deleted messages, permanently.
+    s | Move the selected message to another maildir.
     N | Move to the next unread message.
+    t | Toggle between the threaded, and flat, views.
+    c | Collapse, or expand, the selected thread.
+    o | Cycle through the sort-orders for this maildir.
+    O | Reverse the sort-order for this maildir.
+   ^N | Move to the next thread.
+   ^P | Move to the previous thread.
 
 
 The email-viewing mode has additional keybindings:
@@ -341,6 +919,8 @@ The email-viewing mode has additional keybindings:
   Key | Action
   ----+---------------------------------------------------------
     d | Delete the currently visible message, move to the next.
+    u | Undo the last delete.
+    s | Move the currently visible message to another maildir.
     J | Select the next message.
     K | Select the previous message.
 
@@ -357,7 +937,7 @@ Steve
 		}
 
 		// Update UI
-		p.app.SetRoot(p.helpList, true)
+		p.show(p.helpList)
 		return
 	}
 
@@ -373,8 +953,8 @@ func (p *uiCmd) SearchPrompt() {
 
 	var inputField *tview.InputField
 
-	// Get the old UI element which had focus
-	old := p.app.GetFocus()
+	// Get the old layout which was shown
+	old := p.root
 
 	// Create an input-field for entering the text.
 	inputField = tview.NewInputField().
@@ -406,6 +986,109 @@ func (p *uiCmd) SearchPrompt() {
 
 }
 
+// MovePrompt prompts for a maildir folder, with completion, and moves the
+// selected message into it.
+//
+// This works from the message-list, and when viewing a single message.
+func (p *uiCmd) MovePrompt() {
+
+	var inputField *tview.InputField
+
+	// Get the old layout which was shown
+	old := p.root
+
+	// The folders we can move the message into.
+	find := finder.New(p.prefix)
+	find.Separator = p.separator
+	folders := find.Folders()
+
+	// Create an input-field for entering the folder.
+	inputField = tview.NewInputField().
+		SetLabel("Move to: ").
+		SetFieldWidth(50).
+		SetAutocompleteFunc(func(text string) []string {
+			return completeFolders(folders, text)
+		}).
+		SetDoneFunc(
+			func(key tcell.Key) {
+
+				// Highlight the old widget
+				p.app.SetRoot(old, true)
+
+				if key != tcell.KeyEnter {
+					return
+				}
+
+				// Get the folder
+				val := strings.TrimSpace(inputField.GetText())
+				if len(val) < 1 {
+					return
+				}
+
+				maildir := ""
+				for _, folder := range folders {
+					if folder.Name == val {
+						maildir = folder.Path
+					}
+				}
+				if maildir == "" {
+					p.notify("Unknown folder " + val)
+					return
+				}
+
+				p.MoveMessage(maildir, val)
+			})
+
+	// Make our new input widget the default/only widget.
+	p.app.SetRoot(inputField, true)
+}
+
+// completeFolders returns the logical names of the folders which match
+// the given text, ignoring case.  Folders whose names begin with the
+// text come first.
+func completeFolders(folders []finder.Folder, text string) []string {
+
+	if text == "" {
+		return nil
+	}
+
+	text = strings.ToLower(text)
+
+	var prefix []string
+	var others []string
+	for _, folder := range folders {
+		name := strings.ToLower(folder.Name)
+		if strings.HasPrefix(name, text) {
+			prefix = append(prefix, folder.Name)
+		} else if strings.Contains(name, text) {
+			others = append(others, folder.Name)
+		}
+	}
+
+	return append(prefix, others...)
+}
+
+// MoveMessage moves the selected message into the given maildir, which
+// has the given logical name.
+func (p *uiCmd) MoveMessage(maildir string, name string) {
+
+	move := func(path string) error {
+		_, err := mailreader.Move(path, maildir)
+		return err
+	}
+
+	moved := false
+	if p.mode() == "email" {
+		moved = p.removeCurrentMessage(move)
+	} else {
+		moved = p.removeSelectedMessage(move)
+	}
+
+	if moved {
+		p.notify("Moved message to " + name)
+	}
+}
+
 // Search is called with text and selects the next entry in our
 // list which matches - literally.
 //
@@ -500,19 +1183,25 @@ func (p *uiCmd) PreviousMode() {
 	})
 }
 
-// deleteSelectedMessage deletes the message under the point, in
-// the list of messages.
+// removeSelectedMessage removes the message under the point, in the list
+// of messages, from the current maildir via the given function.
 //
-// You can delete a message as it is being viewed, via the
-// DeleteCurrentMessage function.
-func (p *uiCmd) deleteSelectedMessage() {
+// It returns true if the message was removed, and reports any failure
+// in the status-line.
+func (p *uiCmd) removeSelectedMessage(remove func(path string) error) bool {
 
 	// Get the current entry.
 	selected := p.messageList.GetCurrentItem()
+	if selected < 0 || selected >= len(p.messages) {
+		return false
+	}
 
-	// Delete the file
+	// Remove the file
 	path := p.messages[selected].Path
-	os.Remove(path)
+	if err := remove(path); err != nil {
+		p.notify(err.Error())
+		return false
+	}
 
 	// Reload messages - don't save history
 	p.SetMode("messages", false)
@@ -527,43 +1216,68 @@ func (p *uiCmd) deleteSelectedMessage() {
 
 	// If it is out-of-bounds, decrement
 	p.messageList.SetCurrentItem(selected)
+	return true
 }
 
-// DeleteCurrentMessage is the function that deletes the currently
-// being viewed message, and moves onto the next if possible.
+// removeCurrentMessage removes the message being viewed from the current
+// maildir, via the given function, and moves onto the next if possible.
 //
-// This is distinct from deleting messages while viewing the
-// message-list in the `messages`-mode.
-func (p *uiCmd) DeleteCurrentMessage() {
+// It returns true if the message was removed, and reports any failure
+// in the status-line.
+func (p *uiCmd) removeCurrentMessage(remove func(path string) error) bool {
 
 	// Get the message
 	selected := p.messageList.GetCurrentItem()
+	if selected < 0 || selected >= len(p.messages) {
+		return false
+	}
 	path := p.messages[selected].Path
 
-	// Delete it
-	os.Remove(path)
+	// Remove it
+	if err := remove(path); err != nil {
+		p.notify(err.Error())
+		return false
+	}
 
 	// Remove the entry from the message-list
 	copy(p.messages[selected:], p.messages[selected+1:])
 	p.messages = p.messages[:len(p.messages)-1]
 
-	// Remove the entry from the UI list
-	p.messageList.RemoveItem(selected - 1)
+	for i, msg := range p.allMessages {
+		if msg.Path == path {
+			p.allMessages = append(p.allMessages[:i], p.allMessages[i+1:]...)
+			break
+		}
+	}
+
+	// Remove the entry from the UI list.
+	//
+	// tview panics if we remove the first item while it is selected,
+	// so move the selection away from it first.
+	if selected == 0 && p.messageList.GetItemCount() > 1 {
+		p.messageList.SetCurrentItem(1)
+	}
+	p.messageList.RemoveItem(selected)
+
+	// If that was the last message there's nothing left to view.
+	if len(p.messages) == 0 {
+		p.PreviousMode()
+		return true
+	}
 
 	// Now we have to update the offset of the message
 	// list, and also the history.
-	if selected < len(p.messages) {
-		// this is the element past the previous one,
-		// since we removed an entry.
-		p.modeHistory[len(p.modeHistory)-1].offset = selected
-		p.messageList.SetCurrentItem(selected)
-	} else {
-		if selected >= 1 {
-			p.modeHistory[len(p.modeHistory)-1].offset = selected - 1
-			p.messageList.SetCurrentItem(selected - 1)
-		}
+	if selected >= len(p.messages) {
+		selected = len(p.messages) - 1
 	}
+	p.modeHistory[len(p.modeHistory)-1].offset = selected
+	p.messageList.SetCurrentItem(selected)
+
+	// The change-handler doesn't run if the offset is unchanged.
+	p.curEmail = p.messages[selected].Path
+
 	p.SetMode("email", false)
+	return true
 }
 
 // NextMessage is the function that moves to the next message, from
@@ -625,6 +1339,24 @@ func (p *uiCmd) TUI() {
 			p.Search("[red]")
 			return nil
 		}
+		// toggle the tree
+		if event.Rune() == rune('t') {
+			p.ToggleTree()
+			return nil
+		}
+		// expand/collapse folders in the tree
+		if event.Rune() == rune('c') {
+			p.ToggleFolder()
+			return nil
+		}
+		if event.Key() == tcell.KeyLeft {
+			p.ExpandFolder(false)
+			return nil
+		}
+		if event.Key() == tcell.KeyRight {
+			p.ExpandFolder(true)
+			return nil
+		}
 		return event
 	})
 
@@ -647,6 +1379,49 @@ func (p *uiCmd) TUI() {
 			p.Search("[red]")
 			return nil
 		}
+		// toggle threading
+		if event.Rune() == rune('t') {
+			p.ToggleThreading()
+			return nil
+		}
+		// change the sort-order
+		if event.Rune() == rune('o') {
+			p.CycleSort(false)
+			return nil
+		}
+		if event.Rune() == rune('O') {
+			p.CycleSort(true)
+			return nil
+		}
+		// collapse/expand the current thread
+		if event.Rune() == rune('c') {
+			p.ToggleThread()
+			return nil
+		}
+		// move message to another folder
+		if event.Rune() == rune('s') {
+			p.MovePrompt()
+			return nil
+		}
+		// undo the last delete
+		if event.Rune() == rune('u') {
+			p.UndoDelete()
+			return nil
+		}
+		// purge the deleted messages
+		if event.Rune() == rune('X') {
+			p.Expunge()
+			return nil
+		}
+		// next/previous thread
+		if event.Key() == tcell.KeyCtrlN {
+			p.NextThread()
+			return nil
+		}
+		if event.Key() == tcell.KeyCtrlP {
+			p.PrevThread()
+			return nil
+		}
 		return event
 	})
 
@@ -673,9 +1448,22 @@ func (p *uiCmd) TUI() {
 			p.PrevMessage()
 			return nil
 		}
+		// move message to another folder
+		if event.Rune() == rune('s') {
+			p.MovePrompt()
+			return nil
+		}
+		// undo the last delete
+		if event.Rune() == rune('u') {
+			p.UndoDelete()
+			return nil
+		}
 		return event
 	})
 
+	// The status-line.
+	p.status = tview.NewTextView()
+
 	// Listbox to hold the help-text.
 	p.helpList = tview.NewList()
 	p.helpList.ShowSecondaryText(false)
@@ -727,6 +1515,7 @@ func (p *uiCmd) TUI() {
 
 		// Q: Quit
 		if event.Rune() == rune('Q') {
+			p.stop()
 			p.app.Stop()
 			return nil
 		}
@@ -763,10 +1552,13 @@ func (p *uiCmd) TUI() {
 	// This runs until something calls `app.Stop()` or a
 	// panic is received.
 	//
-	if err := p.app.SetRoot(p.maildirList, true).Run(); err != nil {
+	p.show(p.maildirList)
+	if err := p.app.Run(); err != nil {
 		panic(err)
 	}
 
+	// Stop watching, in case we stopped some other way.
+	p.stop()
 }
 
 //
@@ -786,6 +1578,14 @@ func (*uiCmd) Usage() string {
 func (p *uiCmd) SetFlags(f *flag.FlagSet) {
 	prefix := os.Getenv("HOME") + "/Maildir/"
 	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
+	f.BoolVar(&p.tree, "tree", false, "Show the maildirs as a tree, by default.")
+	f.StringVar(&p.stateFile, "state", defaultStateFile(), "The file to record the expanded folders in, set to empty to disable.")
+	f.StringVar(&p.cache, "cache", cache.DefaultDirectory(), "The directory to cache message-headers in, set to empty to disable.")
+	f.IntVar(&p.jobs, "jobs", runtime.NumCPU(), "The number of maildirs, or messages, to process in parallel.")
+	f.BoolVar(&p.threaded, "threaded", false, "Group messages into threads by default.")
+	f.StringVar(&p.sort, "sort", "mtime", "The order to sort messages in by default.")
+	f.StringVar(&p.trash.maildir, "trash", "", "The maildir to move deleted messages into, if empty they're marked as trashed instead.")
 }
 
 //
@@ -793,6 +1593,36 @@ func (p *uiCmd) SetFlags(f *flag.FlagSet) {
 //
 func (p *uiCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	// No threads are collapsed to begin with.
+	p.collapsed = make(map[string]bool)
+
+	// No maildirs have had their sort-order changed.
+	p.sorts = make(map[string]string)
+
+	// Restore the folders expanded in the tree last time.
+	p.expanded = loadExpanded(p.stateFile)
+
+	// Test the default sort-order now, rather than failing later.
+	if err := sorter.Valid(p.sort); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitUsageError
+	}
+
+	// Find the trash maildir now, rather than failing later.
+	if p.trash.maildir != "" {
+		helper := &messagesCmd{prefix: p.prefix, separator: p.separator}
+		path, err := helper.getMaildirPath(p.trash.maildir)
+		if err == nil {
+			// We compare this against the paths of messages.
+			path, err = filepath.Abs(path)
+		}
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitUsageError
+		}
+		p.trash.maildir = path
+	}
+
 	// Run the TUI
 	p.TUI()
 	return subcommands.ExitSuccess
@@ -0,0 +1,279 @@
+// Watch maildirs, and report changes to the messages within them.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/mail"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/subcommands"
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/formatter"
+	"github.com/skx/maildir-tools/mailreader"
+	"github.com/skx/maildir-tools/watcher"
+)
+
+// watchCmd holds our state
+type watchCmd struct {
+
+	// The prefix to our maildir hierarchy
+	prefix string
+
+	// The format-string to use for displaying events
+	format string
+
+	// Output JSON, one object per line, instead of using our format.
+	jsonl bool
+
+	// The separator to use within logical folder-names.
+	separator string
+}
+
+// WatchEvent is the JSON-representation of an event.
+type WatchEvent struct {
+	Event      string `json:"event"`
+	Folder     string `json:"folder"`
+	Maildir    string `json:"maildir"`
+	File       string `json:"file"`
+	Flags      string `json:"flags"`
+	OldFolder  string `json:"old_folder,omitempty"`
+	OldMaildir string `json:"old_maildir,omitempty"`
+	OldFile    string `json:"old_file,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	Date       string `json:"date,omitempty"`
+	MessageID  string `json:"message_id,omitempty"`
+}
+
+//
+// Glue
+//
+func (*watchCmd) Name() string     { return "watch" }
+func (*watchCmd) Synopsis() string { return "Report changes to messages as they happen." }
+func (*watchCmd) Usage() string {
+	return `watch [folder1 .. folderN] :
+  Watch the given maildir folders, or all maildirs beneath the prefix, and
+ print a line for each message which arrives, changes flags, moves, or is
+ deleted.  This runs until it is interrupted.
+
+  Lines are generated via a format-string, which supports all the fields
+ the 'messages' sub-command does, except #{total}, as well as:
+
+    #{event}        - One of "arrive", "flags", "move", or "delete".
+    #{folder}       - The logical name of the maildir.
+    #{maildir}      - The path to the maildir.
+    #{old_folder}   - The logical name of the previous maildir.
+    #{old_maildir}  - The path to the previous maildir.
+    #{old_file}     - The previous path of the message.
+    #{index}        - The number of the event.
+
+  The previous locations are only set for moves, and flag-changes, and
+ the headers of deleted messages are not available.
+
+  Use '-jsonl' to output one JSON object per line instead, for example:
+
+    maildir-tools watch -jsonl | jq -r 'select(.event=="arrive") | .subject'
+
+  Maildirs created after we've started aren't watched.
+`
+}
+
+//
+// Flag setup
+//
+func (p *watchCmd) SetFlags(f *flag.FlagSet) {
+	prefix := os.Getenv("HOME") + "/Maildir/"
+
+	f.StringVar(&p.prefix, "prefix", prefix, "The prefix directory.")
+	f.StringVar(&p.format, "format", "#{event} #{folder} #{file}", "Specify the format-string to use for events.")
+	f.BoolVar(&p.jsonl, "jsonl", false, "Output one JSON object per event, one per line.")
+	f.StringVar(&p.separator, "separator", finder.DefaultSeparator, "The separator to use within logical folder-names.")
+}
+
+// eventFields holds the fields available to event format-strings, in
+// addition to those of messageMapper, which describe the event itself.
+//
+// Each is given the event, and the logical names of our maildirs.
+var eventFields = map[string]func(ev watcher.Event, names map[string]string) string{
+	"event": func(ev watcher.Event, _ map[string]string) string {
+		return string(ev.Type)
+	},
+	"folder": func(ev watcher.Event, names map[string]string) string {
+		return folderName(names, ev.Maildir)
+	},
+	"maildir": func(ev watcher.Event, _ map[string]string) string {
+		return ev.Maildir
+	},
+	"old_folder": func(ev watcher.Event, names map[string]string) string {
+		return folderName(names, ev.OldMaildir)
+	},
+	"old_maildir": func(ev watcher.Event, _ map[string]string) string {
+		return ev.OldMaildir
+	},
+	"old_file": func(ev watcher.Event, _ map[string]string) string {
+		return ev.OldPath
+	},
+
+	// We never know how many events there will be.
+	"total": func(_ watcher.Event, _ map[string]string) string {
+		return ""
+	},
+}
+
+// eventMapper returns the function used to expand a format-string for
+// the given event, which is about the given message.
+func eventMapper(ev watcher.Event, names map[string]string, mail *mailreader.Email, index int) func(string) string {
+
+	mapper := messageMapper(mail, index, 0)
+
+	return func(field string) string {
+
+		if f, ok := eventFields[field]; ok {
+			return f(ev, names)
+		}
+		return mapper(field)
+	}
+}
+
+// folderName returns the logical name of the given maildir, or its path
+// if it has none.
+func folderName(names map[string]string, maildir string) string {
+
+	if maildir == "" {
+		return ""
+	}
+	if name, ok := names[filepath.Clean(maildir)]; ok {
+		return name
+	}
+	return maildir
+}
+
+// needsHeaders returns true if the given template uses any fields which
+// require the headers of messages to be read.
+func needsHeaders(tmpl *formatter.Template) bool {
+
+	for _, field := range tmpl.Fields() {
+		_, event := eventFields[field]
+		_, message := messageFields[field]
+		if !event && !message {
+			return true
+		}
+	}
+	return false
+}
+
+// eventMessage returns the message an event is about, reading its headers
+// if we should.
+//
+// Deleted messages, or those which can't be read, have no headers.
+func eventMessage(ev watcher.Event, headers bool) *mailreader.Email {
+
+	if headers && ev.Type != watcher.Delete {
+		if email, err := mailreader.New(ev.Path); err == nil {
+			return email
+		}
+	}
+	return mailreader.NewFromHeader(ev.Path, mail.Header{})
+}
+
+//
+// Entry-point.
+//
+func (p *watchCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	find := finder.New(p.prefix)
+	find.Separator = p.separator
+
+	//
+	// Find the logical names of all our folders.
+	//
+	folders := find.Folders()
+	names := make(map[string]string)
+	for _, folder := range folders {
+		names[filepath.Clean(folder.Path)] = folder.Name
+	}
+
+	//
+	// Find the maildirs to watch.
+	//
+	var maildirs []string
+	if len(f.Args()) == 0 {
+		for _, folder := range folders {
+			maildirs = append(maildirs, folder.Path)
+		}
+	}
+
+	helper := &messagesCmd{prefix: p.prefix, separator: p.separator}
+	for _, arg := range f.Args() {
+		path, err := helper.getMaildirPath(arg)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		maildirs = append(maildirs, path)
+	}
+
+	w, err := watcher.New(maildirs)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
+	//
+	// Stop cleanly when we're interrupted.
+	//
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		w.Close()
+	}()
+
+	//
+	// Report each event.
+	//
+	index := 0
+	encoder := json.NewEncoder(os.Stdout)
+
+	tmpl := formatter.Compile(p.format)
+	headers := p.jsonl || needsHeaders(tmpl)
+
+	for events := range w.Events {
+		for _, ev := range events {
+
+			mail := eventMessage(ev, headers)
+
+			if p.jsonl {
+				encoder.Encode(WatchEvent{
+					Event:      string(ev.Type),
+					Folder:     folderName(names, ev.Maildir),
+					Maildir:    ev.Maildir,
+					File:       ev.Path,
+					Flags:      mail.Flags(),
+					OldFolder:  folderName(names, ev.OldMaildir),
+					OldMaildir: ev.OldMaildir,
+					OldFile:    ev.OldPath,
+					From:       mail.Header("From"),
+					To:         mail.Header("To"),
+					Subject:    mail.Header("Subject"),
+					Date:       mail.Header("Date"),
+					MessageID:  mail.Header("Message-ID"),
+				})
+			} else {
+				fmt.Println(tmpl.Render(eventMapper(ev, names, mail, index)))
+			}
+
+			index++
+		}
+	}
+
+	return subcommands.ExitSuccess
+}
@@ -1,22 +1,65 @@
 // Package finder allows for retrieving a list of Maildirs beneath a
 // given prefix, and the messages contained within them.
 //
-// It is a very loose wrapper around `path/filepath` with no particularly
-// special logic.
+// It is a very loose wrapper around `path/filepath`, which understands
+// the common ways of arranging a hierarchy of maildirs:
+//
+//  * Folders nested as directories, as used by Dovecot's LAYOUT=fs.
+//
+//  * Maildir++, as used by Courier and Dovecot, where the prefix is the
+//    INBOX and subfolders are stored within it as ".Lists.golang".
+//
+// In both cases folders are given logical names, such as "Lists/golang",
+// which are independent of their layout on-disk.
 package finder
 
 import (
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+var (
+	// unique matches the time, and optional microseconds, at the
+	// start of a Maildir unique filename, such as
+	// "1577836800.M123456P789.host" or "1577836800.789_1.host".
+	unique = regexp.MustCompile(`^([0-9]+)\.(?:[^.]*M([0-9]+))?`)
+)
+
+// DefaultSeparator is the separator used between the components of
+// logical folder-names, if none is configured.
+const DefaultSeparator = "/"
+
+// Inbox is the logical name of the maildir at the root of our prefix.
+const Inbox = "INBOX"
+
 // Finder holds our state.
 type Finder struct {
 
 	// Prefix is the root directory of the users' maildir hierarchy.
 	Prefix string
+
+	// Separator is placed between the components of logical
+	// folder-names, if empty DefaultSeparator is used.
+	Separator string
+}
+
+// Folder holds the details of a single maildir folder.
+type Folder struct {
+
+	// Path holds the complete path to the maildir.
+	Path string
+
+	// Name holds the logical name of the folder, such as
+	// "Lists/golang", or "INBOX" for the root of the hierarchy.
+	Name string
+
+	// Parts holds the components of the logical name.
+	Parts []string
 }
 
 // New creates a new object which can be used to find Maildir folders,
@@ -25,6 +68,41 @@ func New(prefix string) *Finder {
 	return &Finder{Prefix: prefix}
 }
 
+// MessageFile holds the details of a single message-file.
+type MessageFile struct {
+
+	// Path holds the complete path to the message.
+	Path string
+
+	// ModTime holds the modification-time of the file.
+	ModTime time.Time
+
+	// Size holds the size of the file, in bytes.
+	Size int64
+}
+
+// Arrival returns the time the message was delivered, which is parsed
+// from the unique-name of the file.
+//
+// If the name doesn't contain a time we return the modification-time
+// of the file instead.
+func (m MessageFile) Arrival() time.Time {
+
+	match := unique.FindStringSubmatch(filepath.Base(m.Path))
+	if len(match) == 0 {
+		return m.ModTime
+	}
+
+	secs, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return m.ModTime
+	}
+
+	usecs, _ := strconv.ParseInt(match[2], 10, 64)
+
+	return time.Unix(secs, usecs*1000)
+}
+
 // Messages returns all message-files beneath the given maildir folder.
 //
 // This means we walk the filesystem returning the list of filenames present
@@ -33,20 +111,28 @@ func New(prefix string) *Finder {
 // We exclude non-files, and ignore $path/tmp/.
 func (f *Finder) Messages(path string) []string {
 
-	// We want to sort the files by age.
-	//
-	// Calling stat is expensive, but the appropriate
-	// details are already retrieved by our filesystem
-	// walker - so we store them alongside the names
-	// as we receive them.
-	//
-	type MessageInfo struct {
-		path string
-		info os.FileInfo
+	files := f.MessageFiles(path)
+
+	// Now build up a return value of just the filenames,
+	// which have been sorted by modification time.
+	ret := make([]string, len(files))
+	for i, e := range files {
+		ret[i] = e.Path
 	}
 
+	return ret
+}
+
+// MessageFiles returns the details of all message-files beneath the
+// given maildir folder, sorted by modification-time.
+//
+// Calling stat is expensive, but the appropriate details are already
+// retrieved by our filesystem walker - so we return them alongside the
+// names as we receive them.
+func (f *Finder) MessageFiles(path string) []MessageFile {
+
 	// The holder for the messages we find.
-	var files []MessageInfo
+	var files []MessageFile
 
 	// Directories we examine beneath the maildir
 	dirs := []string{"cur", "new"}
@@ -60,10 +146,15 @@ func (f *Finder) Messages(path string) []string {
 		// Now record all files beneath that directory
 		_ = filepath.Walk(prefix, func(path string, f os.FileInfo, err error) error {
 
+			// Missing directory?
+			if err != nil {
+				return nil
+			}
+
 			// We only care about files
 			mode := f.Mode()
 			if mode.IsRegular() {
-				files = append(files, MessageInfo{path: path, info: f})
+				files = append(files, MessageFile{Path: path, ModTime: f.ModTime(), Size: f.Size()})
 			}
 
 			return nil
@@ -71,25 +162,125 @@ func (f *Finder) Messages(path string) []string {
 	}
 
 	// Sort the files
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].info.ModTime().Unix() < files[j].info.ModTime().Unix()
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime.Unix() < files[j].ModTime.Unix()
 	})
 
-	// Now build up a return value of just the filenames,
-	// which have been sorted by modification time.
-	ret := make([]string, len(files))
-	for i, e := range files {
-		ret[i] = e.path
-	}
-
-	return ret
+	return files
 }
 
 // Maildirs returns the list of Maildir folders beneath our prefix.
 //
-// This function handles recursive/nested maildir folders.
+// This function handles recursive/nested maildir folders, and Maildir++
+// folders, returning them in the order of their logical names.
 func (f *Finder) Maildirs() []string {
 
+	folders := f.Folders()
+
+	maildirs := make([]string, len(folders))
+	for i, folder := range folders {
+		maildirs[i] = folder.Path
+	}
+
+	return maildirs
+}
+
+// Folders returns the details of the Maildir folders beneath our prefix,
+// sorted by their logical names with the INBOX first.
+func (f *Finder) Folders() []Folder {
+
+	var folders []Folder
+
+	for _, path := range f.walk() {
+		parts := f.logicalParts(path)
+		folders = append(folders, Folder{Path: path, Name: f.join(parts), Parts: parts})
+	}
+
+	sort.SliceStable(folders, func(i, j int) bool {
+		a := folders[i].Parts
+		b := folders[j].Parts
+
+		// The INBOX always comes first.
+		if (a[0] == Inbox) != (b[0] == Inbox) {
+			return a[0] == Inbox
+		}
+
+		// Otherwise compare each component in turn, so that
+		// parents come before their children.
+		for k := 0; k < len(a) && k < len(b); k++ {
+			x := strings.ToLower(a[k])
+			y := strings.ToLower(b[k])
+			if x != y {
+				return x < y
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	return folders
+}
+
+// Folder returns the folder with the given logical name.
+func (f *Finder) Folder(name string) (Folder, bool) {
+
+	for _, folder := range f.Folders() {
+		if folder.Name == name {
+			return folder, true
+		}
+	}
+	return Folder{}, false
+}
+
+// Name returns the logical name of the maildir at the given path.
+//
+// Maildirs which aren't beneath our prefix are named by their path.
+func (f *Finder) Name(path string) string {
+
+	rel, err := filepath.Rel(f.Prefix, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return f.join(f.logicalParts(path))
+}
+
+// join joins the components of a logical name with our separator.
+func (f *Finder) join(parts []string) string {
+
+	sep := f.Separator
+	if sep == "" {
+		sep = DefaultSeparator
+	}
+	return strings.Join(parts, sep)
+}
+
+// logicalParts returns the components of the logical name of the maildir
+// at the given path.
+//
+// The root of the hierarchy is the INBOX, directories are components of
+// their own, and Maildir++ directories such as ".Lists.golang" are split
+// upon their periods.
+func (f *Finder) logicalParts(path string) []string {
+
+	rel, err := filepath.Rel(f.Prefix, path)
+	if err != nil || rel == "." {
+		return []string{Inbox}
+	}
+
+	var parts []string
+	for _, dir := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(dir, ".") && len(dir) > 1 {
+			parts = append(parts, strings.Split(dir[1:], ".")...)
+		} else {
+			parts = append(parts, dir)
+		}
+	}
+
+	return parts
+}
+
+// walk returns the paths of all maildirs beneath our prefix.
+func (f *Finder) walk() []string {
+
 	maildirs := []string{}
 
 	// Subdirectories we care about
@@ -98,12 +289,19 @@ func (f *Finder) Maildirs() []string {
 	//
 	// Find maildirs
 	//
-	_ = filepath.Walk(f.Prefix, func(path string, f os.FileInfo, err error) error {
+	root := f.Prefix
+	_ = filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+
+		// Unreadable?
+		if err != nil {
+			return nil
+		}
 
-		// Ignore the new/cur/tmp directories we'll terminate with
+		// Don't descend into the new/cur/tmp directories, which
+		// will hold messages rather than folders.
 		for _, dir := range dirs {
-			if strings.HasSuffix(path, dir) {
-				return nil
+			if path != root && f.IsDir() && filepath.Base(path) == dir {
+				return filepath.SkipDir
 			}
 		}
 
@@ -136,10 +334,5 @@ func (f *Finder) Maildirs() []string {
 		return nil
 	})
 
-	//
-	// Sort
-	//
-	sort.Slice(maildirs, func(i, j int) bool { return strings.ToLower(maildirs[i]) < strings.ToLower(maildirs[j]) })
-
 	return maildirs
 }
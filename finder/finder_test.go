@@ -0,0 +1,98 @@
+package finder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeMaildirs creates maildirs beneath a temporary directory.
+func makeMaildirs(t *testing.T, dirs ...string) string {
+
+	prefix, err := ioutil.TempDir("", "finder")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+
+	for _, dir := range dirs {
+		for _, sub := range []string{"cur", "new", "tmp"} {
+			if err := os.MkdirAll(filepath.Join(prefix, dir, sub), 0755); err != nil {
+				t.Fatalf("failed to create maildir: %s", err)
+			}
+		}
+	}
+	return prefix
+}
+
+func TestFolders(t *testing.T) {
+
+	// A Maildir++ hierarchy, with some LAYOUT=fs folders too.
+	prefix := makeMaildirs(t, "", ".Sent", ".Lists.golang", "Archive/2020", "Archive", "secur")
+	defer os.RemoveAll(prefix)
+
+	// A directory inside a maildir which isn't a folder.
+	os.MkdirAll(filepath.Join(prefix, ".Sent", "cur", "x", "cur"), 0755)
+	os.MkdirAll(filepath.Join(prefix, ".Sent", "cur", "x", "new"), 0755)
+	os.MkdirAll(filepath.Join(prefix, ".Sent", "cur", "x", "tmp"), 0755)
+
+	f := New(prefix)
+
+	var names []string
+	for _, folder := range f.Folders() {
+		names = append(names, folder.Name)
+	}
+
+	expected := "INBOX,Archive,Archive/2020,Lists/golang,secur,Sent"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(names, ","))
+	}
+
+	f.Separator = "."
+	folder, ok := f.Folder("Lists.golang")
+	if !ok {
+		t.Fatalf("failed to find folder by its logical name")
+	}
+	if folder.Path != filepath.Join(prefix, ".Lists.golang") {
+		t.Errorf("unexpected path %s", folder.Path)
+	}
+
+	if name := f.Name(filepath.Join(prefix, "Archive", "2020")); name != "Archive.2020" {
+		t.Errorf("unexpected name %s", name)
+	}
+	if name := f.Name("/elsewhere/maildir"); name != "/elsewhere/maildir" {
+		t.Errorf("unexpected name %s for a maildir outside our prefix", name)
+	}
+	if len(folder.Parts) != 2 || folder.Parts[1] != "golang" {
+		t.Errorf("unexpected parts %v", folder.Parts)
+	}
+
+	if _, ok := f.Folder("Lists/golang"); ok {
+		t.Errorf("found folder with the wrong separator")
+	}
+}
+
+func TestArrival(t *testing.T) {
+
+	mtime := time.Unix(100, 0)
+
+	type TestCase struct {
+		Name     string
+		Expected time.Time
+	}
+
+	tests := []TestCase{
+		{"1577836800.M123456P789.host:2,S", time.Unix(1577836800, 123456000)},
+		{"1577836800.789_1.host", time.Unix(1577836800, 0)},
+		{"unknown", mtime},
+	}
+
+	for _, tst := range tests {
+		m := MessageFile{Path: "/tmp/cur/" + tst.Name, ModTime: mtime}
+		if !m.Arrival().Equal(tst.Expected) {
+			t.Errorf("%s: expected %s, got %s", tst.Name, tst.Expected, m.Arrival())
+		}
+	}
+}
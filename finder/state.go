@@ -0,0 +1,148 @@
+package finder
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// State holds the state of a message, as recorded by its filename.
+//
+// Maildir records the flags of a message after the ":2," marker of its
+// filename, and messages which haven't been seen by a mail-client live
+// in the new/ directory, so we never need to open the file.
+type State struct {
+
+	// Flags holds the flags of the message, in ASCII order:
+	//
+	//   D - Draft.
+	//   F - Flagged.
+	//   P - Passed (i.e. forwarded, resent, or bounced).
+	//   R - Replied.
+	//   S - Seen.
+	//   T - Trashed.
+	Flags string
+
+	// New is true if the message is in the new/ directory.
+	New bool
+}
+
+// ParseState returns the state of the message-file with the given path.
+func ParseState(path string) State {
+
+	flags := ""
+	name := filepath.Base(path)
+	if i := strings.Index(name, ":2,"); i > 0 {
+		flags = name[i+3:]
+	}
+
+	return State{Flags: sortFlags(flags),
+		New: filepath.Base(filepath.Dir(path)) == "new"}
+}
+
+// Unique returns the unique-part of a message's filename, that is the
+// name with the flags removed.
+//
+// The unique-part of a message doesn't change when its flags do, or when
+// it is moved between maildirs.
+func Unique(path string) string {
+
+	name := filepath.Base(path)
+	if i := strings.Index(name, ":2,"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// Has returns true if the message has the given flag.
+func (s State) Has(flag rune) bool {
+	return strings.ContainsRune(s.Flags, flag)
+}
+
+// Unread returns true if the message is new, or hasn't been seen.
+func (s State) Unread() bool {
+	return s.New || !s.Has('S')
+}
+
+// Flagged returns true if the message has been flagged.
+func (s State) Flagged() bool {
+	return s.Has('F')
+}
+
+// Replied returns true if the message has been replied to.
+func (s State) Replied() bool {
+	return s.Has('R')
+}
+
+// Trashed returns true if the message has been marked for deletion.
+func (s State) Trashed() bool {
+	return s.Has('T')
+}
+
+// Draft returns true if the message is a draft.
+func (s State) Draft() bool {
+	return s.Has('D')
+}
+
+// String returns the flags of the message, with the pseudo-flag "N"
+// added if the message is unread, in ASCII order.
+func (s State) String() string {
+
+	if s.Unread() {
+		return sortFlags(s.Flags + "N")
+	}
+	return s.Flags
+}
+
+// sortFlags returns the given flags in ASCII order.
+func sortFlags(flags string) string {
+
+	s := strings.Split(flags, "")
+	sort.Strings(s)
+	return strings.Join(s, "")
+}
+
+// Counts holds the number of messages in each state, within a maildir.
+type Counts struct {
+	Total   int
+	Unread  int
+	New     int
+	Flagged int
+	Replied int
+	Trashed int
+	Draft   int
+}
+
+// Add counts the given message.
+func (c *Counts) Add(s State) {
+
+	c.Total++
+	if s.Unread() {
+		c.Unread++
+	}
+	if s.New {
+		c.New++
+	}
+	if s.Flagged() {
+		c.Flagged++
+	}
+	if s.Replied() {
+		c.Replied++
+	}
+	if s.Trashed() {
+		c.Trashed++
+	}
+	if s.Draft() {
+		c.Draft++
+	}
+}
+
+// CountStates returns the counts of the states of the given message-files.
+func CountStates(paths []string) Counts {
+
+	var c Counts
+	for _, path := range paths {
+		c.Add(ParseState(path))
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+package finder
+
+import (
+	"testing"
+)
+
+func TestState(t *testing.T) {
+
+	type TestCase struct {
+		Path    string
+		Flags   string
+		String  string
+		Unread  bool
+		Flagged bool
+		Replied bool
+		Trashed bool
+		Draft   bool
+	}
+
+	tests := []TestCase{
+		{"/m/new/1.host", "", "N", true, false, false, false, false},
+		{"/m/new/1.host:2,S", "S", "NS", true, false, false, false, false},
+		{"/m/cur/1.host:2,", "", "N", true, false, false, false, false},
+		{"/m/cur/1.host:2,S", "S", "S", false, false, false, false, false},
+		{"/m/cur/1.host:2,SRF", "FRS", "FRS", false, true, true, false, false},
+		{"/m/cur/1.host:2,TS", "ST", "ST", false, false, false, true, false},
+		{"/m/cur/1.host:2,D", "D", "DN", true, false, false, false, true},
+		{"/m/cur/1.host", "", "N", true, false, false, false, false},
+	}
+
+	for _, tst := range tests {
+
+		s := ParseState(tst.Path)
+
+		if s.Flags != tst.Flags {
+			t.Errorf("%s: expected flags '%s', got '%s'", tst.Path, tst.Flags, s.Flags)
+		}
+		if s.String() != tst.String {
+			t.Errorf("%s: expected string '%s', got '%s'", tst.Path, tst.String, s.String())
+		}
+		if s.Unread() != tst.Unread || s.Flagged() != tst.Flagged || s.Replied() != tst.Replied || s.Trashed() != tst.Trashed || s.Draft() != tst.Draft {
+			t.Errorf("%s: unexpected state %+v", tst.Path, s)
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+
+	for _, path := range []string{"/m/new/1.host", "/m/cur/1.host:2,S", "/x/cur/1.host:2,"} {
+		if Unique(path) != "1.host" {
+			t.Errorf("%s: unexpected unique name %s", path, Unique(path))
+		}
+	}
+}
+
+func TestCountStates(t *testing.T) {
+
+	c := CountStates([]string{
+		"/m/new/1.host",
+		"/m/cur/2.host:2,S",
+		"/m/cur/3.host:2,",
+		"/m/cur/4.host:2,FS",
+		"/m/cur/5.host:2,RS",
+		"/m/cur/6.host:2,ST",
+		"/m/cur/7.host:2,DS",
+	})
+
+	expected := Counts{Total: 7, Unread: 2, New: 1, Flagged: 1, Replied: 1, Trashed: 1, Draft: 1}
+	if c != expected {
+		t.Errorf("expected %+v, got %+v", expected, c)
+	}
+}
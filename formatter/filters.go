@@ -0,0 +1,271 @@
+package formatter
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Filter transforms the value of a field.
+//
+// The argument is the text given after a colon in the format-string,
+// so "#{date|strftime:%Y-%m-%d}" calls the strftime filter with the
+// argument "%Y-%m-%d", or the empty string if there was none.
+type Filter func(value string, arg string) string
+
+var (
+	// filters holds the filters which may be used, by name.
+	filters = map[string]Filter{
+		"lower":    func(value, _ string) string { return strings.ToLower(value) },
+		"upper":    func(value, _ string) string { return strings.ToUpper(value) },
+		"trim":     func(value, _ string) string { return strings.TrimSpace(value) },
+		"strip_re": stripRe,
+		"strftime": strftime,
+		"relative": relative,
+		"human":    human,
+		"initials": initials,
+		"count":    count,
+	}
+
+	// filtersLock protects our filters.
+	filtersLock sync.RWMutex
+
+	// replyPrefix matches the prefixes added to subjects by replies,
+	// and forwards, such as "Re:", "Fwd:", and "Re[2]:".
+	replyPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|sv)(\[[0-9]+\])?\s*:\s*`)
+
+	// now returns the current time, and is replaced during testing.
+	now = time.Now
+)
+
+// RegisterFilter makes the given filter available to format-strings,
+// replacing any existing filter with the same name.
+func RegisterFilter(name string, filter Filter) {
+	filtersLock.Lock()
+	defer filtersLock.Unlock()
+
+	filters[name] = filter
+}
+
+// getFilter returns the filter with the given name, if any.
+func getFilter(name string) (Filter, bool) {
+	filtersLock.RLock()
+	defer filtersLock.RUnlock()
+
+	filter, ok := filters[name]
+	return filter, ok
+}
+
+// HumanSize returns the given size in a human-readable form, in the
+// same style as mutt.
+func HumanSize(size int64) string {
+
+	if size < 1024 {
+		return fmt.Sprintf("%d", size)
+	}
+
+	if size < 1024*1024 {
+		k := float64(size) / 1024
+		if k < 10 {
+			return fmt.Sprintf("%.1fK", k)
+		}
+		return fmt.Sprintf("%.0fK", k)
+	}
+
+	m := float64(size) / (1024 * 1024)
+	if m < 10 {
+		return fmt.Sprintf("%.1fM", m)
+	}
+	return fmt.Sprintf("%.0fM", m)
+}
+
+// human formats a number of bytes via HumanSize.
+func human(value, _ string) string {
+
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return value
+	}
+	return HumanSize(size)
+}
+
+// stripRe removes the prefixes added to a subject by replies, and
+// forwards.
+func stripRe(value, _ string) string {
+
+	for replyPrefix.MatchString(value) {
+		value = replyPrefix.ReplaceAllString(value, "")
+	}
+	return value
+}
+
+// parseDate parses a date, as found in the Date-header.
+func parseDate(value string) (time.Time, bool) {
+
+	t, err := mail.ParseDate(strings.TrimSpace(value))
+	if err != nil {
+		return t, false
+	}
+	return t, true
+}
+
+// strftime formats a date via the given strftime-style format-string.
+//
+// Values which aren't dates are returned unchanged.
+func strftime(value, arg string) string {
+
+	t, ok := parseDate(value)
+	if !ok {
+		return value
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(arg); i++ {
+
+		if arg[i] != '%' || i == len(arg)-1 {
+			out.WriteByte(arg[i])
+			continue
+		}
+
+		i++
+		switch arg[i] {
+		case 'Y':
+			out.WriteString(t.Format("2006"))
+		case 'y':
+			out.WriteString(t.Format("06"))
+		case 'm':
+			out.WriteString(t.Format("01"))
+		case 'd':
+			out.WriteString(t.Format("02"))
+		case 'e':
+			out.WriteString(fmt.Sprintf("%2d", t.Day()))
+		case 'H':
+			out.WriteString(t.Format("15"))
+		case 'I':
+			out.WriteString(t.Format("03"))
+		case 'M':
+			out.WriteString(t.Format("04"))
+		case 'S':
+			out.WriteString(t.Format("05"))
+		case 'p':
+			out.WriteString(t.Format("PM"))
+		case 'a':
+			out.WriteString(t.Format("Mon"))
+		case 'A':
+			out.WriteString(t.Format("Monday"))
+		case 'b':
+			out.WriteString(t.Format("Jan"))
+		case 'B':
+			out.WriteString(t.Format("January"))
+		case 'j':
+			out.WriteString(fmt.Sprintf("%03d", t.YearDay()))
+		case 'z':
+			out.WriteString(t.Format("-0700"))
+		case 'Z':
+			out.WriteString(t.Format("MST"))
+		case 's':
+			out.WriteString(fmt.Sprintf("%d", t.Unix()))
+		case '%':
+			out.WriteString("%")
+		default:
+			out.WriteByte('%')
+			out.WriteByte(arg[i])
+		}
+	}
+	return out.String()
+}
+
+// relative describes a date relative to now, such as "3 hours ago".
+//
+// Values which aren't dates are returned unchanged.
+func relative(value, _ string) string {
+
+	t, ok := parseDate(value)
+	if !ok {
+		return value
+	}
+
+	diff := now().Sub(t)
+	future := diff < 0
+	if future {
+		diff = -diff
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+
+	for _, unit := range units {
+		n := int(diff / unit.size)
+		if n < 1 {
+			continue
+		}
+
+		desc := fmt.Sprintf("%d %s", n, unit.name)
+		if n > 1 {
+			desc += "s"
+		}
+		if future {
+			return "in " + desc
+		}
+		return desc + " ago"
+	}
+	return "just now"
+}
+
+// initials returns the initials of the first name in an address-header,
+// or the first letter of the address if there is no name.
+func initials(value, _ string) string {
+
+	words := strings.Fields(value)
+	if addrs, err := mail.ParseAddressList(value); err == nil && len(addrs) > 0 {
+		words = strings.Fields(addrs[0].Name)
+		if len(words) == 0 {
+			words = []string{addrs[0].Address}
+		}
+	}
+
+	out := ""
+	for _, word := range words {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				out += string(unicode.ToUpper(r))
+				break
+			}
+		}
+	}
+	return out
+}
+
+// count returns the number of addresses in an address-header.
+func count(value, _ string) string {
+
+	if strings.TrimSpace(value) == "" {
+		return "0"
+	}
+	if addrs, err := mail.ParseAddressList(value); err == nil {
+		return fmt.Sprintf("%d", len(addrs))
+	}
+
+	// Count the non-empty entries if the header is malformed.
+	n := 0
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) != "" {
+			n++
+		}
+	}
+	return fmt.Sprintf("%d", n)
+}
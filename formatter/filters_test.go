@@ -0,0 +1,110 @@
+package formatter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilters(t *testing.T) {
+
+	now = func() time.Time { return time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	mapper := func(placeholderName string) string {
+		switch placeholderName {
+		case "subject":
+			return "  Re: Fwd: Hello World  "
+		case "date":
+			return "Wed, 08 Jan 2020 09:30:00 +0000"
+		case "future":
+			return "Fri, 10 Jan 2020 15:00:00 +0000"
+		case "size":
+			return "15360"
+		case "from":
+			return "\"Steve Kemp\" <steve@steve.fi>"
+		case "bare":
+			return "steve@steve.fi"
+		case "to":
+			return "a@example.com, Bob <b@example.com>, c@example.com"
+		}
+		return ""
+	}
+
+	type TestCase struct {
+		Format string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"#{subject|trim}", "Re: Fwd: Hello World"},
+		{"#{subject|lower|trim}", "re: fwd: hello world"},
+		{"#{subject|strip_re|upper|trim}", "HELLO WORLD"},
+		{"#{date|strftime:%Y-%m-%d %H:%M}", "2020-01-08 09:30"},
+		{"#{date|strftime:%a %e %b, 100%%}", "Wed  8 Jan, 100%"},
+		{"#{date|strftime:%Y年%m月%d日}", "2020年01月08日"},
+		{"#{date|strftime:%é}", "%é"},
+		{"#{subject|strftime:%Y}", "  Re: Fwd: Hello World  "},
+		{"#{date|relative}", "2 days ago"},
+		{"#{future|relative}", "in 3 hours"},
+		{"#{size|human}", "15K"},
+		{"#{6size|human}", "   15K"},
+		{"#{from|initials}", "SK"},
+		{"#{bare|initials}", "S"},
+		{"#{to|count}", "3"},
+		{"#{cc|count}", "0"},
+		{"#{cc|upper|(none)}", "(none)"},
+		{"#{cc|(none)|upper}", "(NONE)"},
+		{"#{?to|count>2:many}", "many"},
+		{"#{subject|unknown: text}", "  Re: Fwd: Hello World  "},
+	}
+
+	for _, tst := range tests {
+		out := Expand(tst.Format, mapper)
+		if out != tst.Output {
+			t.Errorf("%s: expected '%s', got '%s'", tst.Format, tst.Output, out)
+		}
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+
+	RegisterFilter("wrap", func(value string, arg string) string {
+		if arg == "" {
+			arg = "*"
+		}
+		return arg + value + arg
+	})
+
+	mapper := func(placeholderName string) string {
+		return "ab"
+	}
+
+	out := Expand("#{x|wrap} #{x|wrap:__}", mapper)
+	if out != "*ab* __ab__" {
+		t.Errorf("Got unexpected output:" + out)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+
+	type TestCase struct {
+		Size   int64
+		Output string
+	}
+
+	tests := []TestCase{
+		{0, "0"},
+		{1023, "1023"},
+		{1024, "1.0K"},
+		{1536, "1.5K"},
+		{20 * 1024, "20K"},
+		{5 * 1024 * 1024, "5.0M"},
+		{50 * 1024 * 1024, "50M"},
+	}
+
+	for _, tst := range tests {
+		if out := HumanSize(tst.Size); out != tst.Output {
+			t.Errorf("%d: expected '%s', got '%s'", tst.Size, tst.Output, out)
+		}
+	}
+}
@@ -2,113 +2,490 @@
 //
 // This is almost a direct port of `os.Expand`, however there is support
 // for using field-sizes as well as different deliminators.
+//
+// Beyond simple variables a format-string may contain:
+//
+//  * Filters, which transform the value of a field: "#{subject|lower|trim}".
+//
+//  * Default values, used if a field is empty: "#{subject|(no subject)}".
+//
+//  * Conditional text, shown only if a condition holds: "#{?cc:Cc: #{cc}}",
+//    or only if it doesn't: "#{!cc:No Cc}".
+//
+// Conditions are either a field-name, which is true if the field isn't
+// empty, or a comparison of a field against a value such as "unread>0".
+//
+// Field-widths are measured in terminal columns, rather than bytes, so
+// East Asian wide characters count as two columns and multi-byte
+// characters are never split when truncating.
+//
+// A format-string which is used repeatedly should be parsed once, via
+// Compile, and the resulting Template rendered for each record.
 package formatter
 
 import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/mattn/go-runewidth"
 )
 
 var (
-	// Helper lets us find variables to expand.
-	helper = regexp.MustCompile("^(.*?)#{([^}]+)}(.*)$")
-
-	// Length specifies the field-length.
-	length = regexp.MustCompile("^([0-9]+)(.*)$")
+	// Length specifies the alignment, field-length, and whether to
+	// use an ellipsis when truncating.
+	length = regexp.MustCompile("^([-^]?)([0-9]+)(\\.?)(.*)$")
 
 	// Mail gets an email address from a field
 	emailRE = regexp.MustCompile(".*?(<.*>)$")
 
 	// Mail gets an email address from a field
 	nameRE = regexp.MustCompile("^\"(.*)\".*<.*>$")
+
+	// Comparison splits a condition into a field, operator, and value.
+	comparison = regexp.MustCompile("^([^!=<>~]+?)\\s*(!=|>=|<=|=|~|>|<)(.*)$")
 )
 
+// Template is a parsed format-string, which may be rendered many times.
+type Template struct {
+
+	// nodes holds the literal text, and variables, of the template.
+	nodes []node
+
+	// fields holds the names of the fields the template references.
+	fields []string
+}
+
+// node is a single piece of a template.
+type node interface {
+
+	// render returns the expansion of the node.
+	render(mapping func(string) string) string
+}
+
+// literal is a node of plain text.
+type literal string
+
+// variable is a node which expands a field, as "#{20subject|lower}".
+type variable struct {
+
+	// field holds the name of the field.
+	field string
+
+	// name and email are true if the field had the ".name" or
+	// ".email" suffixes, to extract part of an address.
+	name  bool
+	email bool
+
+	// steps holds the filters, and defaults, to apply in turn.
+	steps []step
+
+	// alignment, padding, and ellipsis describe how the value should
+	// be padded or truncated, if padding isn't empty.
+	alignment string
+	padding   string
+	ellipsis  bool
+}
+
+// step is a single filter, or default value, within a variable.
+type step struct {
+
+	// filter holds the filter to apply, if this isn't a default.
+	filter Filter
+
+	// arg holds the argument to the filter.
+	arg string
+
+	// def holds the default value, if this isn't a filter.
+	def *Template
+}
+
+// conditional is a node of text which is only shown if a condition holds,
+// as "#{?cc:Cc: #{cc}}".
+type conditional struct {
+
+	// negate is true if the text should be shown when the condition
+	// doesn't hold.
+	negate bool
+
+	// value holds the field being tested.
+	value *variable
+
+	// op and other hold the comparison, if any.
+	op    string
+	other string
+
+	// text holds the text to show.
+	text *Template
+}
+
 // Expand replaces ${var} or $var in the string based on the mapping function.
 func Expand(format string, mapping func(string) string) string {
+	return Compile(format).Render(mapping)
+}
 
-	// For email we allow "to.name" or "#{to.email}" to
-	// return just the part of the matching field.
-	//
-	// That goes for Cc too, and all other fields.
-	name := false
-	email := false
+// Compile parses the given format-string into a template.
+//
+// Malformed variables, such as those which aren't closed, are treated as
+// literal text.  Filters are resolved when the template is compiled, so
+// must be registered before then.
+func Compile(format string) *Template {
 
-	out := ""
+	t := &Template{}
 
-	match := helper.FindStringSubmatch(format)
+	for {
+		start := strings.Index(format, "#{")
+		if start < 0 {
+			break
+		}
 
-	for len(match) > 0 {
+		// Find the end of this variable, allowing for any
+		// variables nested inside it.
+		end := closing(format, start+2)
+		if end < 0 {
+			break
+		}
 
-		// Get the field-name we should interpolate.
-		field := match[2]
+		// Add the prefix
+		if start > 0 {
+			t.nodes = append(t.nodes, literal(format[:start]))
+		}
 
-		// Look for a padding/truncation setup.
-		padding := ""
-		pMatches := length.FindStringSubmatch(field)
-		if len(pMatches) > 0 {
-			padding = pMatches[1]
-			field = pMatches[2]
+		// Empty variables are left alone.
+		body := format[start+2 : end]
+		if body == "" {
+			t.nodes = append(t.nodes, literal("#{}"))
+		} else {
+			t.nodes = append(t.nodes, t.parseVariable(body))
 		}
 
-		// Email-specific modifiers?
-		if strings.HasSuffix(field, ".name") {
-			name = true
-			field = strings.TrimSuffix(field, ".name")
+		// Move on.
+		format = format[end+1:]
+	}
+
+	// Add on the format-string if it didn't match,
+	// or any trailing suffix if it did.
+	if format != "" {
+		t.nodes = append(t.nodes, literal(format))
+	}
+
+	return t
+}
+
+// Render expands the template, using the mapping function to find the
+// values of fields.
+func (t *Template) Render(mapping func(string) string) string {
+
+	var out strings.Builder
+	for _, n := range t.nodes {
+		out.WriteString(n.render(mapping))
+	}
+	return out.String()
+}
+
+// Fields returns the names of the fields the template references, in the
+// order they first appear, including those used only in conditions or
+// default values.
+//
+// Field names don't include the ".name" or ".email" suffixes.
+func (t *Template) Fields() []string {
+	return t.fields
+}
+
+// Uses returns true if the template references any of the given fields.
+func (t *Template) Uses(fields ...string) bool {
+
+	for _, field := range t.fields {
+		for _, f := range fields {
+			if field == f {
+				return true
+			}
 		}
-		if strings.HasSuffix(field, ".email") {
-			email = true
-			field = strings.TrimSuffix(field, ".email")
+	}
+	return false
+}
+
+// addField records that the template references the given field.
+func (t *Template) addField(field string) {
+
+	for _, f := range t.fields {
+		if f == field {
+			return
 		}
+	}
+	t.fields = append(t.fields, field)
+}
 
-		// Add the prefix
-		out += match[1]
+// addFields records that the template references the fields of another.
+func (t *Template) addFields(other *Template) {
 
-		// Get the field-value, via the callback
-		output := mapping(field)
+	for _, field := range other.fields {
+		t.addField(field)
+	}
+}
 
-		if email {
-			eMatches := emailRE.FindStringSubmatch(output)
-			if len(eMatches) == 2 {
-				output = eMatches[1]
-			}
+// closing returns the offset of the "}" which closes the variable
+// starting at the given offset, or -1 if it isn't closed.
+func closing(format string, offset int) int {
+
+	depth := 1
+	for i := offset; i < len(format); i++ {
+		if strings.HasPrefix(format[i:], "#{") {
+			depth++
+			i++
+			continue
 		}
-		if name {
-			nMatches := nameRE.FindStringSubmatch(output)
-			if len(nMatches) == 2 {
-				output = nMatches[1]
+		if format[i] == '}' {
+			depth--
+			if depth == 0 {
+				return i
 			}
 		}
+	}
+	return -1
+}
+
+// split splits the given string at the first occurrence of the separator
+// which isn't inside a nested variable.
+func split(str string, sep byte) (string, string, bool) {
+
+	depth := 0
+	for i := 0; i < len(str); i++ {
+		switch {
+		case strings.HasPrefix(str[i:], "#{"):
+			depth++
+			i++
+		case str[i] == '}' && depth > 0:
+			depth--
+		case str[i] == sep && depth == 0:
+			return str[:i], str[i+1:], true
+		}
+	}
+	return str, "", false
+}
 
-		if padding != "" {
+// parseVariable parses a single variable, without the surrounding "#{"
+// and "}".
+func (t *Template) parseVariable(body string) node {
 
-			// padding character
-			char := " "
-			if padding[0] == byte('0') {
-				char = "0"
-			}
+	// Conditional text?
+	if body[0] == '?' || body[0] == '!' {
 
-			// size we need to pad to
-			size, _ := strconv.Atoi(padding)
-			for len(output) < size {
-				output = char + output
-			}
+		cond, text, _ := split(body[1:], ':')
 
-			// or truncate to
-			if len(output) > size {
-				output = output[:size]
-			}
+		c := &conditional{negate: body[0] == '!', text: Compile(text)}
+
+		match := comparison.FindStringSubmatch(cond)
+		if match == nil {
+			c.value = t.parsePipeline(strings.TrimSpace(cond))
+		} else {
+			c.value = t.parsePipeline(strings.TrimSpace(match[1]))
+			c.op = match[2]
+			c.other = match[3]
 		}
 
-		// Add the value
-		out += output
+		t.addFields(c.text)
+		return c
+	}
 
-		// Move on.
-		format = match[3]
-		match = helper.FindStringSubmatch(format)
+	// Look for a padding/truncation setup.
+	pMatches := length.FindStringSubmatch(body)
+	if len(pMatches) == 0 {
+		return t.parsePipeline(body)
 	}
 
-	// Add on the format-string if it didn't match,
-	// or any trailing suffix if it did.
-	return out + format
+	v := t.parsePipeline(pMatches[4])
+	v.alignment = pMatches[1]
+	v.padding = pMatches[2]
+	v.ellipsis = pMatches[3] != ""
+	return v
+}
+
+// parsePipeline parses a field followed by any number of filters and
+// default values, separated by "|".
+//
+// Each segment which names a registered filter, optionally followed by
+// ":" and an argument, is applied to the value.  Any other segment is a
+// default, which is used if the value is empty at that point.
+func (t *Template) parsePipeline(body string) *variable {
+
+	field, rest, more := split(body, '|')
+
+	v := &variable{}
+
+	// For email we allow "to.name" or "#{to.email}" to
+	// return just the part of the matching field.
+	//
+	// That goes for Cc too, and all other fields.
+	if strings.HasSuffix(field, ".name") {
+		v.name = true
+		field = strings.TrimSuffix(field, ".name")
+	}
+	if strings.HasSuffix(field, ".email") {
+		v.email = true
+		field = strings.TrimSuffix(field, ".email")
+	}
+	v.field = field
+	t.addField(field)
+
+	for more {
+		var segment string
+		segment, rest, more = split(rest, '|')
+
+		name, arg, _ := split(segment, ':')
+		if filter, ok := getFilter(name); ok {
+			v.steps = append(v.steps, step{filter: filter, arg: arg})
+			continue
+		}
+
+		def := Compile(segment)
+		t.addFields(def)
+		v.steps = append(v.steps, step{def: def})
+	}
+
+	return v
+}
+
+// render returns the literal text.
+func (l literal) render(mapping func(string) string) string {
+	return string(l)
+}
+
+// render returns the value of the variable, padded or truncated.
+func (v *variable) render(mapping func(string) string) string {
+
+	output := v.value(mapping)
+	if v.padding == "" {
+		return output
+	}
+	return align(output, v.alignment, v.padding, v.ellipsis)
+}
+
+// value returns the value of the field, passed through any filters.
+func (v *variable) value(mapping func(string) string) string {
+
+	// Get the field-value, via the callback
+	output := mapping(v.field)
+
+	if v.email {
+		eMatches := emailRE.FindStringSubmatch(output)
+		if len(eMatches) == 2 {
+			output = eMatches[1]
+		}
+	}
+	if v.name {
+		nMatches := nameRE.FindStringSubmatch(output)
+		if len(nMatches) == 2 {
+			output = nMatches[1]
+		}
+	}
+
+	for _, s := range v.steps {
+		if s.filter != nil {
+			output = s.filter(output, s.arg)
+		} else if output == "" {
+			output = s.def.Render(mapping)
+		}
+	}
+
+	return output
+}
+
+// render returns the text, if the condition holds.
+func (c *conditional) render(mapping func(string) string) string {
+
+	if c.evaluate(mapping) != c.negate {
+		return c.text.Render(mapping)
+	}
+	return ""
+}
+
+// evaluate returns the result of testing the condition.
+//
+// A condition which is just a field-name, optionally followed by filters,
+// is true if the field isn't empty, otherwise the field is compared
+// against a value.  The "~" operator tests whether the field contains
+// the value, the others compare numerically if both sides are numbers,
+// or as strings if not.
+func (c *conditional) evaluate(mapping func(string) string) bool {
+
+	value := c.value.value(mapping)
+
+	switch c.op {
+	case "":
+		return value != ""
+	case "~":
+		return strings.Contains(value, c.other)
+	}
+
+	// Compare numerically if we can.
+	result := strings.Compare(value, c.other)
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(c.other, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			result = -1
+		case a > b:
+			result = 1
+		default:
+			result = 0
+		}
+	}
+
+	switch c.op {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+	return false
+}
+
+// align pads, or truncates, the given value to the width specified by
+// padding, which will be padded with zeros if it begins with "0".
+//
+// The value is aligned to the right, unless alignment is "-" to align
+// it to the left or "^" to centre it.  If ellipsis is true truncated
+// values end with "…".
+func align(output string, alignment string, padding string, ellipsis bool) string {
+
+	// size we need to pad, or truncate, to
+	size, _ := strconv.Atoi(padding)
+
+	// truncate first, so that the result is padded if the
+	// truncation removed a wide character.
+	tail := ""
+	if ellipsis {
+		tail = "…"
+	}
+	output = runewidth.Truncate(output, size, tail)
+
+	// padding character, zeros only make sense on the left.
+	char := " "
+	if padding[0] == byte('0') && alignment == "" {
+		char = "0"
+	}
+
+	// the number of columns we need to fill
+	fill := size - runewidth.StringWidth(output)
+	if fill <= 0 {
+		return output
+	}
+
+	switch alignment {
+	case "-":
+		return output + strings.Repeat(char, fill)
+	case "^":
+		return strings.Repeat(char, fill/2) + output + strings.Repeat(char, fill-fill/2)
+	}
+	return strings.Repeat(char, fill) + output
 }
@@ -1,6 +1,7 @@
 package formatter
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -105,3 +106,206 @@ func TestEmail(t *testing.T) {
 		t.Errorf("Got unexpected output:" + out)
 	}
 }
+
+func TestConditionals(t *testing.T) {
+
+	mapper := func(placeholderName string) string {
+		switch placeholderName {
+		case "cc":
+			return "Steve <steve@steve.fi>"
+		case "flags":
+			return "NS"
+		case "subject":
+			return "Hello"
+		case "unread":
+			return "10"
+		case "total":
+			return "9"
+		}
+		return ""
+	}
+
+	type TestCase struct {
+		Format string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"#{?cc:Cc: #{cc}}", "Cc: Steve <steve@steve.fi>"},
+		{"#{?bcc:Bcc: #{bcc}}", ""},
+		{"#{!bcc:No Bcc}", "No Bcc"},
+		{"#{!cc:No Cc}", ""},
+		{"#{?flags~N:[red]}#{subject}", "[red]Hello"},
+		{"#{?flags~F:[red]}#{subject}", "Hello"},
+		{"#{?unread>0:new}", "new"},
+		{"#{?unread>9:more}", "more"},
+		{"#{?unread>total:more}", ""},
+		{"#{?unread<=10:ok}", "ok"},
+		{"#{?subject=Hello:yes}", "yes"},
+		{"#{?subject!=Hello:no}", ""},
+		{"#{?subject=Bye:yes}", ""},
+		{"#{?cc.name~Steve:#{cc.email}}", "<steve@steve.fi>"},
+		{"#{?cc:#{?subject:both}}", "both"},
+		{"#{?cc}", ""},
+	}
+
+	for _, tst := range tests {
+		out := Expand(tst.Format, mapper)
+		if out != tst.Output {
+			t.Errorf("%s: expected '%s', got '%s'", tst.Format, tst.Output, out)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+
+	mapper := func(placeholderName string) string {
+		switch placeholderName {
+		case "subject":
+			return "Hello"
+		case "file":
+			return "/tmp/1.host"
+		}
+		return ""
+	}
+
+	type TestCase struct {
+		Format string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"#{subject|(no subject)}", "Hello"},
+		{"#{cc|(no cc)}", "(no cc)"},
+		{"#{cc|}", ""},
+		{"#{cc|#{file}}", "/tmp/1.host"},
+		{"#{8cc|none}", "    none"},
+		{"[#{cc|#{missing|?}}]", "[?]"},
+		{"#{} and #{unterminated", "#{} and #{unterminated"},
+	}
+
+	for _, tst := range tests {
+		out := Expand(tst.Format, mapper)
+		if out != tst.Output {
+			t.Errorf("%s: expected '%s', got '%s'", tst.Format, tst.Output, out)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+
+	mapper := func(placeholderName string) string {
+		switch placeholderName {
+		case "latin":
+			return "Grüße aus Köln"
+		case "cjk":
+			return "日本語の件名"
+		case "emoji":
+			return "🎉 party"
+		}
+		return ""
+	}
+
+	type TestCase struct {
+		Format string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"#{5latin}", "Grüße"},
+		{"#{16latin}", "  Grüße aus Köln"},
+		{"#{4cjk}", "日本"},
+		{"#{5cjk}", " 日本"},
+		{"#{14cjk}", "  日本語の件名"},
+		{"#{3emoji}", "🎉 "},
+		{"#{-10emoji}", "🎉 party  "},
+		{"#{3cjk}|", " 日|"},
+	}
+
+	for _, tst := range tests {
+		out := Expand(tst.Format, mapper)
+		if out != tst.Output {
+			t.Errorf("%s: expected '%s', got '%s'", tst.Format, tst.Output, out)
+		}
+	}
+}
+
+func TestAlignment(t *testing.T) {
+
+	mapper := func(placeholderName string) string {
+		switch placeholderName {
+		case "subject":
+			return "Hello World"
+		case "index":
+			return "7"
+		case "cjk":
+			return "日本語の件名"
+		}
+		return ""
+	}
+
+	type TestCase struct {
+		Format string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"[#{-8index}]", "[7       ]"},
+		{"[#{-08index}]", "[7       ]"},
+		{"[#{03index}]", "[007]"},
+		{"[#{^5index}]", "[  7  ]"},
+		{"[#{^6index}]", "[  7   ]"},
+		{"[#{-5subject}]", "[Hello]"},
+		{"[#{^15subject}]", "[  Hello World  ]"},
+		{"[#{8.subject}]", "[Hello W…]"},
+		{"[#{-20.subject}]", "[Hello World         ]"},
+		{"[#{7.cjk}]", "[日本語…]"},
+		{"[#{8.cjk}]", "[ 日本語…]"},
+		{"[#{-8.cjk}]", "[日本語… ]"},
+		{"[#{-8subject|upper}]", "[HELLO WO]"},
+	}
+
+	for _, tst := range tests {
+		out := Expand(tst.Format, mapper)
+		if out != tst.Output {
+			t.Errorf("%s: expected '%s', got '%s'", tst.Format, tst.Output, out)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+
+	tmpl := Compile("[#{06index}] #{from.name|#{from.email}} #{?cc:Cc: #{cc}} #{subject|lower|(none)} #{!unread>0:read}")
+
+	fields := strings.Join(tmpl.Fields(), ",")
+	if fields != "index,from,cc,subject,unread" {
+		t.Errorf("unexpected fields: %s", fields)
+	}
+
+	if !tmpl.Uses("total", "unread") {
+		t.Errorf("expected the template to use unread")
+	}
+	if tmpl.Uses("total", "size") {
+		t.Errorf("didn't expect the template to use total, or size")
+	}
+
+	// The template may be rendered repeatedly.
+	for i, expected := range []string{
+		"[000001] Steve Cc: Bob hello read",
+		"[000002] <x@example.com>  (none) ",
+	} {
+		values := []map[string]string{
+			{"index": "1", "from": "\"Steve\" <steve@steve.fi>", "cc": "Bob", "subject": "Hello", "unread": "0"},
+			{"index": "2", "from": "<x@example.com>", "unread": "3"},
+		}[i]
+
+		out := tmpl.Render(func(field string) string { return values[field] })
+		if out != expected {
+			t.Errorf("expected '%s', got '%s'", expected, out)
+		}
+	}
+
+	if len(Compile("plain text").Fields()) != 0 {
+		t.Errorf("expected no fields in plain text")
+	}
+}
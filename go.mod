@@ -3,8 +3,11 @@ module github.com/skx/maildir-tools
 go 1.13
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gdamore/tcell v1.3.0
 	github.com/google/subcommands v1.0.1
 	github.com/jhillyerd/enmime v0.7.0
+	github.com/mattn/go-runewidth v0.0.4
 	github.com/rivo/tview v0.0.0-20200108161608-1316ea7a4b35
+	golang.org/x/net v0.0.0-20190724013045-ca1201d0de80
 )
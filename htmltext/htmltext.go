@@ -0,0 +1,493 @@
+// Package htmltext converts HTML documents, such as the bodies of
+// HTML-only email messages, into readable plain-text.
+//
+// The conversion is deliberately simple, but preserves the structure
+// that matters when reading mail in a terminal:
+//
+//  * Paragraphs, headings, and other blocks are separated by blank lines.
+//
+//  * Lists are indented, with bullets or numbers.
+//
+//  * Links are followed by a numbered reference, with the URLs listed as
+//    footnotes at the end of the text.
+//
+//  * Blockquotes are prefixed with "> ", as they would be in a reply.
+//
+//  * Simple tables are aligned in columns.
+//
+// Scripts, styles, and other invisible content are discarded.
+package htmltext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// blankLines matches runs of blank lines, which we collapse.
+	blankLines = regexp.MustCompile("\n(\\s*\n){2,}")
+)
+
+// converter holds our state while we walk the document.
+type converter struct {
+
+	// out holds our output.
+	out strings.Builder
+
+	// prefix holds the strings written at the start of each line,
+	// for indentation and quoting.
+	prefix []string
+
+	// marker holds a list-bullet which should replace the last
+	// prefix on the next line we write.
+	marker string
+
+	// newlines holds the number of line-breaks which should be
+	// written before the next piece of text.
+	newlines int
+
+	// blank holds the prefix that was in effect when line-breaks
+	// were first requested, which is used for any blank lines.
+	blank string
+
+	// space is true if a space should be written before the next
+	// piece of text.
+	space bool
+
+	// lineStart is true if nothing has been written to the current
+	// line.
+	lineStart bool
+
+	// pre is non-zero if we're inside a <pre> block.
+	pre int
+
+	// lists holds the depth of the lists we're inside.
+	lists int
+
+	// links holds the URLs of the links we've found, which is shared
+	// with any converters used to render table-cells.
+	links *[]string
+}
+
+// Convert converts the given HTML into plain-text.
+func Convert(input string) string {
+
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return input
+	}
+
+	links := []string{}
+	c := newConverter(&links)
+	c.walk(doc)
+
+	out := c.String()
+
+	// Add the links as footnotes.
+	if len(links) > 0 {
+		out += "\n\n"
+		for i, link := range links {
+			out += fmt.Sprintf("[%d] %s\n", i+1, link)
+		}
+	}
+
+	return strings.TrimRight(out, "\n") + "\n"
+}
+
+// newConverter creates a new converter, sharing the given list of links.
+func newConverter(links *[]string) *converter {
+	return &converter{links: links, lineStart: true}
+}
+
+// String returns the text we've converted so far, tidied up.
+func (c *converter) String() string {
+
+	out := blankLines.ReplaceAllString(c.out.String(), "\n\n")
+	return strings.Trim(out, "\n")
+}
+
+// block ensures the next text we write is separated from the previous
+// text by the given number of line-breaks.
+func (c *converter) block(n int) {
+	if c.newlines == 0 {
+		c.blank = strings.Join(c.prefix, "")
+	}
+	if n > c.newlines {
+		c.newlines = n
+	}
+	c.space = false
+}
+
+// newline adds a single line-break before the next piece of text.
+func (c *converter) newline() {
+	c.block(c.newlines + 1)
+}
+
+// linePrefix returns the prefix for the start of a new line.
+func (c *converter) linePrefix() string {
+
+	if c.marker != "" && len(c.prefix) > 0 {
+		p := strings.Join(c.prefix[:len(c.prefix)-1], "") + c.marker
+		c.marker = ""
+		return p
+	}
+	return strings.Join(c.prefix, "")
+}
+
+// write adds the given text to our output, handling any pending
+// line-breaks, prefixes, and spaces first.
+func (c *converter) write(text string) {
+
+	if c.newlines > 0 && c.out.Len() > 0 {
+
+		// Blank lines inside quotes should still be quoted, but
+		// only by the quotes which surround both lines.
+		blank := strings.Join(c.prefix, "")
+		for i := 0; i < len(blank) && i < len(c.blank); i++ {
+			if blank[i] != c.blank[i] {
+				blank = blank[:i]
+				break
+			}
+		}
+		if len(c.blank) < len(blank) {
+			blank = c.blank
+		}
+		blank = strings.TrimRight(blank, " ")
+
+		for i := 0; i < c.newlines; i++ {
+			c.out.WriteString("\n")
+			if i < c.newlines-1 {
+				c.out.WriteString(blank)
+			}
+		}
+		c.lineStart = true
+	}
+	c.newlines = 0
+
+	if c.lineStart {
+		c.out.WriteString(c.linePrefix())
+		c.lineStart = false
+	} else if c.space {
+		c.out.WriteString(" ")
+	}
+	c.space = false
+
+	c.out.WriteString(text)
+}
+
+// text handles a text-node.
+func (c *converter) text(text string) {
+
+	// Preformatted text is written as-is, line by line.
+	if c.pre > 0 {
+		c.lines(text)
+		return
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		if text != "" {
+			c.space = !c.lineStart
+		}
+		return
+	}
+
+	// Leading whitespace separates us from the previous text.
+	if strings.TrimLeft(text, " \t\r\n") != text && !c.lineStart {
+		c.space = true
+	}
+
+	for i, word := range words {
+		if i > 0 {
+			c.space = true
+		}
+		c.write(word)
+	}
+
+	// Trailing whitespace separates us from the next text.
+	if strings.TrimRight(text, " \t\r\n") != text {
+		c.space = true
+	}
+}
+
+// attr returns the value of the named attribute of the given node.
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// children walks the children of the given node.
+func (c *converter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+// walk converts the given node, and its children.
+func (c *converter) walk(n *html.Node) {
+
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.DocumentNode:
+		c.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+
+	// Invisible content.
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Noscript, atom.Template:
+		return
+
+	case atom.Br:
+		c.newline()
+
+	case atom.Hr:
+		c.block(2)
+		c.write("----------")
+		c.block(2)
+
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer,
+		atom.Address, atom.Center, atom.Dl, atom.Form, atom.Fieldset, atom.Figure:
+		c.block(2)
+		c.children(n)
+		c.block(2)
+
+	case atom.Dt, atom.Dd, atom.Caption:
+		c.block(1)
+		c.children(n)
+		c.block(1)
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.block(2)
+		level := int(n.Data[1] - '0')
+		c.write(strings.Repeat("#", level))
+		c.space = true
+		c.children(n)
+		c.block(2)
+
+	case atom.Pre:
+		c.block(2)
+		c.pre++
+		c.children(n)
+		c.pre--
+		c.block(2)
+
+	case atom.Blockquote:
+		c.block(2)
+		c.prefix = append(c.prefix, "> ")
+		c.children(n)
+		c.block(2)
+		c.prefix = c.prefix[:len(c.prefix)-1]
+
+	case atom.Ul, atom.Ol:
+		c.list(n)
+
+	case atom.Li:
+		// List items outside a list.
+		c.block(1)
+		c.children(n)
+		c.block(1)
+
+	case atom.A:
+		c.link(n)
+
+	case atom.Img:
+		alt := strings.TrimSpace(attr(n, "alt"))
+		if alt != "" {
+			c.write("[" + alt + "]")
+		}
+
+	case atom.Table:
+		c.table(n)
+
+	default:
+		c.children(n)
+	}
+}
+
+// list converts an ordered, or unordered, list.
+func (c *converter) list(n *html.Node) {
+
+	// Nested lists aren't separated by blank lines.
+	gap := 2
+	if c.lists > 0 {
+		gap = 1
+	}
+	c.lists++
+
+	c.block(gap)
+
+	index := 1
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			c.walk(li)
+			continue
+		}
+
+		marker := "* "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", index)
+		}
+		index++
+
+		c.block(1)
+		c.prefix = append(c.prefix, strings.Repeat(" ", len(marker)))
+		c.marker = marker
+		c.children(li)
+		c.marker = ""
+		c.prefix = c.prefix[:len(c.prefix)-1]
+		c.block(1)
+	}
+
+	c.lists--
+	c.block(gap)
+}
+
+// link converts a hyperlink, adding the URL to our footnotes.
+func (c *converter) link(n *html.Node) {
+
+	start := c.out.Len()
+	c.children(n)
+	text := strings.TrimSpace(c.out.String()[start:])
+
+	href := strings.TrimSpace(attr(n, "href"))
+
+	// Ignore anchors, and links which are identical to their text.
+	if href == "" || strings.HasPrefix(href, "#") || href == text || "mailto:"+text == href {
+		return
+	}
+
+	*c.links = append(*c.links, href)
+	c.write(fmt.Sprintf("[%d]", len(*c.links)))
+}
+
+// elements returns the child-elements of the given node, with the
+// given types, looking through any <thead>, <tbody>, and <tfoot>
+// elements.
+func elements(n *html.Node, types ...atom.Atom) []*html.Node {
+
+	var ret []*html.Node
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		switch child.DataAtom {
+		case atom.Thead, atom.Tbody, atom.Tfoot:
+			ret = append(ret, elements(child, types...)...)
+			continue
+		}
+		for _, t := range types {
+			if child.DataAtom == t {
+				ret = append(ret, child)
+			}
+		}
+	}
+	return ret
+}
+
+// table converts a table.
+//
+// If every cell contains a single line of text we align the cells into
+// columns, otherwise (as with tables used for layout) we render each
+// cell as a block of its own.
+func (c *converter) table(n *html.Node) {
+
+	var rows [][]string
+	simple := true
+
+	for _, tr := range elements(n, atom.Tr) {
+
+		var row []string
+		for _, td := range elements(tr, atom.Td, atom.Th) {
+
+			cell := newConverter(c.links)
+			cell.children(td)
+			text := cell.String()
+
+			if strings.Contains(text, "\n") {
+				simple = false
+			}
+			row = append(row, text)
+		}
+		rows = append(rows, row)
+	}
+
+	c.block(2)
+
+	if !simple {
+		for _, row := range rows {
+			for _, cell := range row {
+				c.block(2)
+				c.lines(cell)
+			}
+		}
+		c.block(2)
+		return
+	}
+
+	// Find the width of each column.
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := runewidth.StringWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	for _, row := range rows {
+
+		line := ""
+		for i, cell := range row {
+			if i > 0 {
+				line += " | "
+			}
+			line += cell
+			if i < len(row)-1 {
+				line += strings.Repeat(" ", widths[i]-runewidth.StringWidth(cell))
+			}
+		}
+
+		line = strings.TrimRight(line, " ")
+		if line != "" {
+			c.block(1)
+			c.write(line)
+		}
+	}
+
+	c.block(2)
+}
+
+// lines writes the given, already converted, text line by line so that
+// it receives our current prefix.
+func (c *converter) lines(text string) {
+
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			c.newline()
+		}
+		if line != "" {
+			c.write(line)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package htmltext
+
+import (
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+
+	type TestCase struct {
+		Input  string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"<p>Hello   <b>World</b></p>", "Hello World\n"},
+		{"<style>p { color: red; }</style><script>alert(1)</script><p>Text</p>", "Text\n"},
+		{"<p>One</p><p>Two</p>", "One\n\nTwo\n"},
+		{"Line<br>Break", "Line\nBreak\n"},
+		{"<h2>Title</h2>Text", "## Title\n\nText\n"},
+		{"&lt;tag&gt; &amp; entities", "<tag> & entities\n"},
+		{"<ul><li>One</li><li>Two</li></ul>", "* One\n* Two\n"},
+		{"<ol><li>One</li><li>Two<ol><li>Nested</li></ol></li></ol>", "1. One\n2. Two\n   1. Nested\n"},
+		{"<blockquote>Quoted<blockquote>Deeper</blockquote></blockquote>", "> Quoted\n>\n> > Deeper\n"},
+		{"<pre>a\n  b</pre>", "a\n  b\n"},
+		{"<img src=\"logo.png\" alt=\"Logo\">", "[Logo]\n"},
+		{"See <a href=\"http://example.com/\">this</a>.", "See this[1].\n\n[1] http://example.com/\n"},
+		{"<a href=\"http://example.com/\">http://example.com/</a>", "http://example.com/\n"},
+		{"<table><tr><th>Name</th><th>Age</th></tr><tr><td>Steve</td><td>40</td></tr></table>", "Name  | Age\nSteve | 40\n"},
+	}
+
+	for _, test := range tests {
+		out := Convert(test.Input)
+		if out != test.Output {
+			t.Errorf("unexpected output for '%s':\n'%s'\nexpected:\n'%s'", test.Input, out, test.Output)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package mailreader
+
+import (
+	"github.com/jhillyerd/enmime"
+)
+
+// Attachment holds the details of a single attachment, or inline-part,
+// of a message.
+type Attachment struct {
+
+	// Filename holds the (decoded) filename of the attachment, which
+	// might be empty.
+	//
+	// NOTE: This comes from the message, so it must not be trusted
+	// when writing to disk.
+	Filename string
+
+	// ContentType holds the MIME-type of the attachment.
+	ContentType string
+
+	// ContentID holds the Content-ID of the attachment, which is used
+	// to reference inline images from HTML parts.
+	ContentID string
+
+	// Inline is true if the part is intended to be displayed inline,
+	// rather than saved.
+	Inline bool
+
+	// Size holds the decoded size of the attachment, in bytes.
+	Size int
+
+	// Content holds the decoded content of the attachment.
+	Content []byte
+}
+
+// Attachments returns the attachments, and inline-parts, of the message
+// in the order in which they appear.
+//
+// Accessing attachments requires the message-body to be parsed, so if
+// the message was created via New it will be upgraded.
+func (m *Email) Attachments() ([]Attachment, error) {
+
+	if err := m.Upgrade(); err != nil {
+		return nil, err
+	}
+
+	// Record the parts enmime considers to be attachments, or inline.
+	inline := make(map[*enmime.Part]bool)
+	for _, p := range m.Enmime.Attachments {
+		inline[p] = false
+	}
+	for _, p := range m.Enmime.Inlines {
+		inline[p] = true
+	}
+	for _, p := range m.Enmime.OtherParts {
+		if p.FirstChild == nil {
+			inline[p] = true
+		}
+	}
+
+	var ret []Attachment
+
+	// Walk the tree, so that we return things in order.
+	var walk func(p *enmime.Part)
+	walk = func(p *enmime.Part) {
+		for ; p != nil; p = p.NextSibling {
+			if in, ok := inline[p]; ok {
+				ret = append(ret, Attachment{
+					Filename:    p.FileName,
+					ContentType: p.ContentType,
+					ContentID:   p.ContentID,
+					Inline:      in,
+					Size:        len(p.Content),
+					Content:     p.Content,
+				})
+			}
+			walk(p.FirstChild)
+		}
+	}
+	walk(m.Enmime.Root)
+
+	return ret, nil
+}
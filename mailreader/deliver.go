@@ -0,0 +1,209 @@
+package mailreader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/skx/maildir-tools/finder"
+)
+
+var (
+	// deliveries counts the messages we've delivered, so that names
+	// generated within the same microsecond are still unique.
+	deliveries uint64
+)
+
+// UniqueName returns a new unique-name for a message, in the format
+// recommended by the Maildir specification:
+//
+//   1577836800.M123456P789Q1.hostname
+//
+// That is the time of delivery, in seconds and microseconds, our process
+// ID, a counter of deliveries, and the name of the host.
+func UniqueName() string {
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+
+	// The hostname mustn't contain the characters we use as
+	// separators.
+	host = strings.Replace(host, "/", "\\057", -1)
+	host = strings.Replace(host, ":", "\\072", -1)
+
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000,
+		os.Getpid(), atomic.AddUint64(&deliveries, 1), host)
+}
+
+// Copy delivers a copy of the given message-file into the specified
+// maildir, returning the path of the copy.
+//
+// The copy is given a new unique-name, but keeps the flags of the
+// original and its modification-time.  Messages in new/ are copied into
+// new/, and all others into cur/.
+//
+// We follow the Maildir delivery protocol: the message is written into
+// tmp/, synced to disk, and then renamed into place.  That means it
+// works across filesystems, and the copy can never be seen partially
+// written.
+func Copy(file string, maildir string) (string, error) {
+
+	dst, err := deliver(file, maildir, UniqueName())
+	if os.IsExist(err) {
+		return "", fmt.Errorf("failed to deliver %s - %s already exists", file, dst)
+	}
+	return dst, err
+}
+
+// deliver writes a copy of the given message-file into the specified
+// maildir, with the given unique-name, returning the path of the copy.
+//
+// If a message with that name already exists the error satisfies
+// os.IsExist, and the path which was taken is returned with it.
+func deliver(file string, maildir string, name string) (string, error) {
+
+	// The target must be a maildir.
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		info, err := os.Stat(filepath.Join(maildir, sub))
+		if err != nil || !info.IsDir() {
+			return "", fmt.Errorf("%s is not a maildir", maildir)
+		}
+	}
+
+	src, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Work out where the copy will live.
+	state := finder.ParseState(file)
+
+	dst := filepath.Join(maildir, "new", name)
+	if !state.New {
+		dst = filepath.Join(maildir, "cur", name+":2,"+state.Flags)
+	}
+
+	// Write the message into tmp/, refusing to overwrite anything.
+	tmp := filepath.Join(maildir, "tmp", name)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return dst, err
+	}
+
+	_, err = io.Copy(out, src)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	// Keep the modification-time, as we sort messages by it.
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+
+	// Don't clobber any existing message.
+	if err = rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		if os.IsExist(err) {
+			return dst, err
+		}
+		return "", err
+	}
+
+	// Ensure the rename itself is on-disk.
+	syncDir(filepath.Dir(dst))
+
+	return dst, nil
+}
+
+// Move moves the given message-file into the specified maildir, returning
+// its new path.
+//
+// The message is delivered as a copy, like Copy, and the original is only
+// removed once the copy is safely on-disk.  The message keeps its
+// unique-name, which is still unique within the target maildir, so that
+// it can be recognised as the same message - by the watcher, for example.
+// If that name is already taken in the target a new one is used.
+//
+// If the original can't be removed the copy is, so that a failed move
+// never leaves the message in both maildirs.  Moving a message into the
+// maildir which already contains it does nothing.
+func Move(file string, maildir string) (string, error) {
+
+	current := filepath.Dir(filepath.Dir(file))
+	if same(current, maildir) {
+		return file, nil
+	}
+
+	dst, err := deliver(file, maildir, finder.Unique(file))
+	if os.IsExist(err) {
+		dst, err = Copy(file, maildir)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.Remove(file); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	syncDir(filepath.Dir(file))
+
+	return dst, nil
+}
+
+// Move moves this message into the specified maildir, updating the
+// Filename to reflect its new location.
+func (m *Email) Move(maildir string) error {
+
+	path, err := Move(m.Filename, maildir)
+	if err != nil {
+		return err
+	}
+
+	m.Filename = path
+	return nil
+}
+
+// same returns true if the two paths refer to the same directory.
+func same(a string, b string) bool {
+
+	x, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	y, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(x, y)
+}
+
+// syncDir flushes the given directory to disk, so that renames within
+// it are durable.  Failures are ignored, as not all systems allow it.
+func syncDir(dir string) {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,171 @@
+package mailreader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/maildir-tools/finder"
+)
+
+func TestUniqueName(t *testing.T) {
+
+	a := UniqueName()
+	b := UniqueName()
+
+	if a == b {
+		t.Errorf("expected unique names, got %s twice", a)
+	}
+	if strings.ContainsAny(a, "/:") {
+		t.Errorf("unique name contains separators: %s", a)
+	}
+
+	m := finder.MessageFile{Path: "/tmp/new/" + a}
+	if time.Since(m.Arrival()) > time.Minute {
+		t.Errorf("failed to parse the arrival time from %s", a)
+	}
+}
+
+func TestCopy(t *testing.T) {
+
+	src, path := makeMaildir(t, "cur/1234.host:2,FS")
+	defer os.RemoveAll(src)
+
+	dst, _ := makeMaildir(t, "new/other.host")
+	defer os.RemoveAll(dst)
+
+	mtime := time.Unix(1577836800, 0)
+	os.Chtimes(path, mtime, mtime)
+
+	out, err := Copy(path, dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if filepath.Dir(out) != filepath.Join(dst, "cur") {
+		t.Errorf("unexpected location %s", out)
+	}
+	if !strings.HasSuffix(out, ":2,FS") {
+		t.Errorf("flags weren't kept: %s", out)
+	}
+	if finder.Unique(out) == "1234.host" {
+		t.Errorf("expected a new unique name: %s", out)
+	}
+
+	content, err := ioutil.ReadFile(out)
+	if err != nil || string(content) != "Subject: test\n\nBody\n" {
+		t.Errorf("unexpected content %q: %v", content, err)
+	}
+
+	info, err := os.Stat(out)
+	if err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("modification-time wasn't kept")
+	}
+
+	// The original is untouched, and tmp/ is empty.
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("original message is missing: %s", err)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dst, "tmp")); len(files) != 0 {
+		t.Errorf("tmp/ isn't empty")
+	}
+
+	// Copying into something which isn't a maildir fails.
+	if _, err := Copy(path, filepath.Join(dst, "cur")); err == nil {
+		t.Errorf("expected an error copying into a non-maildir")
+	}
+}
+
+func TestMove(t *testing.T) {
+
+	src, path := makeMaildir(t, "new/1234.host")
+	defer os.RemoveAll(src)
+
+	dst, _ := makeMaildir(t, "new/other.host")
+	defer os.RemoveAll(dst)
+
+	m := &Email{Filename: path}
+	if err := m.Move(dst); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if m.Filename != filepath.Join(dst, "new", "1234.host") {
+		t.Errorf("unexpected location %s", m.Filename)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("original message still exists")
+	}
+
+	// Moving into the same maildir does nothing.
+	out, err := Move(m.Filename, dst)
+	if err != nil || out != m.Filename {
+		t.Errorf("unexpected result moving into the same maildir: %s %v", out, err)
+	}
+}
+
+func TestMoveExists(t *testing.T) {
+
+	src, path := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(src)
+
+	dst, existing := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(dst)
+
+	// The unique-name is taken, so a new one is used.
+	out, err := Move(path, dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out == existing || filepath.Dir(out) != filepath.Join(dst, "cur") || !strings.HasSuffix(out, ":2,S") {
+		t.Errorf("unexpected location %s", out)
+	}
+	if _, err := os.Stat(existing); err != nil {
+		t.Errorf("existing message is missing: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("original message still exists")
+	}
+}
+
+func TestMoveReadOnly(t *testing.T) {
+
+	src, path := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(src)
+
+	dst, _ := makeMaildir(t, "new/other.host")
+	defer os.RemoveAll(dst)
+
+	// The original can't be removed from a read-only directory.
+	cur := filepath.Join(src, "cur")
+	if err := os.Chmod(cur, 0555); err != nil {
+		t.Fatalf("failed to make directory read-only: %s", err)
+	}
+	defer os.Chmod(cur, 0755)
+
+	// Unless we're root.
+	probe := filepath.Join(cur, "probe")
+	if err := ioutil.WriteFile(probe, nil, 0644); err == nil {
+		os.Remove(probe)
+		t.Skip("read-only directories are writable by this user")
+	}
+
+	out, err := Move(path, dst)
+	if err == nil {
+		t.Fatalf("expected an error moving from a read-only directory, got %s", out)
+	}
+	if out != "" {
+		t.Errorf("unexpected path after failing: %s", out)
+	}
+
+	// The message is only in its original location.
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("original message is missing: %s", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dst, "cur", "*"))
+	if len(files) != 0 {
+		t.Errorf("copy was left behind: %v", files)
+	}
+}
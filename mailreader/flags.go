@@ -0,0 +1,129 @@
+package mailreader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skx/maildir-tools/finder"
+)
+
+// ValidFlags contains the Maildir flags which we allow to be added to,
+// or removed from, a message:
+//
+//   D - Draft.
+//   F - Flagged.
+//   P - Passed (i.e. forwarded, resent, or bounced).
+//   R - Replied.
+//   S - Seen.
+//   T - Trashed.
+const ValidFlags = "DFPRST"
+
+// ChangeFlags adds and removes flags to/from the given message-file,
+// returning the new path of the message.
+//
+// Flags are stored in the filename of the message, after the ":2,"
+// marker, so changing them means renaming the file.  Messages which
+// live in the new/ directory are moved into cur/ as part of that
+// rename, as required by the Maildir specification.
+//
+// The rename is atomic, and we refuse to overwrite any existing file.
+// See rename for the details.
+func ChangeFlags(file string, add string, remove string) (string, error) {
+
+	// Ensure the flags we've been given are valid.
+	for _, c := range add + remove {
+		if !strings.ContainsRune(ValidFlags, c) {
+			return file, fmt.Errorf("invalid flag '%c' - valid flags are %s", c, ValidFlags)
+		}
+	}
+
+	// The message must live in new/ or cur/ of a maildir.
+	dir := filepath.Dir(file)
+	sub := filepath.Base(dir)
+	if sub != "new" && sub != "cur" {
+		return file, fmt.Errorf("%s is not inside a maildir new/ or cur/ directory", file)
+	}
+
+	// Split the filename into the unique-part, and the flags.
+	name := finder.Unique(file)
+	flags := finder.ParseState(file).Flags
+
+	// Build up the new set of flags.
+	set := make(map[rune]bool)
+	for _, c := range flags {
+		set[c] = true
+	}
+	for _, c := range remove {
+		delete(set, c)
+	}
+	for _, c := range add {
+		set[c] = true
+	}
+
+	// Flags must be stored in ASCII order.
+	var s []string
+	for c := range set {
+		s = append(s, string(c))
+	}
+	sort.Strings(s)
+
+	// The new path is always beneath cur/
+	dst := filepath.Join(filepath.Dir(dir), "cur", name+":2,"+strings.Join(s, ""))
+	if dst == file {
+		return file, nil
+	}
+
+	if err := rename(file, dst); err != nil {
+		if os.IsExist(err) {
+			return file, fmt.Errorf("failed to rename %s - %s already exists", file, dst)
+		}
+		return file, err
+	}
+
+	return dst, nil
+}
+
+// rename renames a message-file, without ever overwriting an existing
+// file - in which case an error satisfying os.IsExist is returned.
+//
+// We hard-link the file to its new name, which fails atomically if that
+// name is taken, then remove the old name.  That's what the Maildir
+// specification suggests, but some filesystems don't support hard-links
+// so in that case we fall back to checking for the new name before we
+// rename - which could race with another process creating it.
+func rename(src string, dst string) error {
+
+	err := os.Link(src, dst)
+	if err == nil {
+		if err = os.Remove(src); err != nil {
+			// Don't leave the message with two names.
+			os.Remove(dst)
+			return err
+		}
+		return nil
+	}
+	if os.IsExist(err) {
+		return err
+	}
+
+	if _, err = os.Lstat(dst); err == nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: os.ErrExist}
+	}
+	return os.Rename(src, dst)
+}
+
+// ChangeFlags adds and removes flags to/from this message, updating
+// the Filename to reflect the new location of the message on-disk.
+func (m *Email) ChangeFlags(add string, remove string) error {
+
+	path, err := ChangeFlags(m.Filename, add, remove)
+	if err != nil {
+		return err
+	}
+
+	m.Filename = path
+	return nil
+}
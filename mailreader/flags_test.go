@@ -0,0 +1,121 @@
+package mailreader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeMaildir creates a temporary maildir, containing a single message
+// at the given relative path.
+func makeMaildir(t *testing.T, message string) (string, string) {
+
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		os.MkdirAll(filepath.Join(dir, sub), 0755)
+	}
+
+	path := filepath.Join(dir, message)
+	err = ioutil.WriteFile(path, []byte("Subject: test\n\nBody\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	return dir, path
+}
+
+func TestChangeFlagsNew(t *testing.T) {
+
+	dir, path := makeMaildir(t, "new/1234.host")
+	defer os.RemoveAll(dir)
+
+	out, err := ChangeFlags(path, "S", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := filepath.Join(dir, "cur", "1234.host:2,S")
+	if out != expected {
+		t.Errorf("unexpected path %s, expected %s", out, expected)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("message wasn't renamed: %s", err)
+	}
+}
+
+func TestChangeFlagsOrder(t *testing.T) {
+
+	dir, path := makeMaildir(t, "cur/1234.host:2,ST")
+	defer os.RemoveAll(dir)
+
+	m := &Email{Filename: path}
+
+	err := m.ChangeFlags("RF", "T")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := filepath.Join(dir, "cur", "1234.host:2,FRS")
+	if m.Filename != expected {
+		t.Errorf("unexpected path %s, expected %s", m.Filename, expected)
+	}
+
+	// A no-op change shouldn't touch anything.
+	out, err := ChangeFlags(m.Filename, "S", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out != expected {
+		t.Errorf("unexpected path %s, expected %s", out, expected)
+	}
+}
+
+func TestChangeFlagsInvalid(t *testing.T) {
+
+	dir, path := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(dir)
+
+	_, err := ChangeFlags(path, "X", "")
+	if err == nil {
+		t.Errorf("expected error with an invalid flag")
+	}
+
+	_, err = ChangeFlags(filepath.Join(dir, "foo"), "S", "")
+	if err == nil {
+		t.Errorf("expected error with a file outside a maildir")
+	}
+}
+
+func TestChangeFlagsExists(t *testing.T) {
+
+	dir, path := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(dir)
+
+	// Another message already has the name we want.
+	taken := filepath.Join(dir, "cur", "1234.host:2,FS")
+	if err := ioutil.WriteFile(taken, []byte("other"), 0644); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	out, err := ChangeFlags(path, "F", "")
+	if err == nil {
+		t.Fatalf("expected an error when the new name exists")
+	}
+	if out != path {
+		t.Errorf("unexpected path %s, expected %s", out, path)
+	}
+
+	// Neither file was touched.
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("original message was removed: %s", err)
+	}
+	content, _ := ioutil.ReadFile(taken)
+	if string(content) != "other" {
+		t.Errorf("existing message was overwritten")
+	}
+}
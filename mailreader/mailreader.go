@@ -14,20 +14,24 @@
 //
 // Header-only access?  Fast?  Use New().
 //
-// Need the body?  Use NewEnmime.
+// Need the body?  Use NewEnmime, or call Upgrade() on the result of New().
 package mailreader
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"mime"
 	"net/mail"
+	"net/textproto"
 	"os"
 	"sort"
-	"strings"
+	"time"
 
 	"github.com/jhillyerd/enmime"
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/htmltext"
 )
 
 // Email holds the state for this message-object.
@@ -39,34 +43,46 @@ type Email struct {
 	// Message holds the mail message - if we're using
 	// the golang parser (which we do for message-indexes
 	// as it is faster).
+	//
+	// Only the headers are read, so the Body will be empty.
 	Message *mail.Message
 
 	// Enmime holds the enmime handle to the message,
-	// which we use when we want access to the body
-	// attachments (TODO), etc.
+	// which we use when we want access to the body,
+	// attachments, etc.
 	Enmime *enmime.Envelope
 
 	// Use enmime?
 	_enmime bool
+
+	// header holds the raw header-block of the message, if it
+	// was read via New.
+	header []byte
 }
 
 // New creates a new mail-reading object which will use the
 // golang mail-package to parse the message.
 //
 // Using this function will allow you access to the header-values
-// easily, but not the message-body.
+// easily, but not the message-body.  Only the header-block of the
+// message is read from disk, so large attachments don't slow us down.
+//
+// If you later decide you need the body you can call Upgrade.
 func New(file string) (*Email, error) {
 	x := &Email{Filename: file}
 
-	var content []byte
-	var err error
+	f, err := os.Open(file)
+	if err != nil {
+		return x, err
+	}
+	defer f.Close()
 
-	content, err = ioutil.ReadFile(file)
+	x.header, err = readHeader(bufio.NewReader(f))
 	if err != nil {
 		return x, err
 	}
 
-	x.Message, err = mail.ReadMessage(bytes.NewReader(content))
+	x.Message, err = mail.ReadMessage(bytes.NewReader(x.header))
 	if err != nil {
 		return x, err
 	}
@@ -74,6 +90,79 @@ func New(file string) (*Email, error) {
 	return x, nil
 }
 
+// readHeader reads the header-block of a message, up to and including
+// the blank line which terminates it.
+func readHeader(r *bufio.Reader) ([]byte, error) {
+
+	var header []byte
+
+	for {
+		line, err := r.ReadBytes('\n')
+		header = append(header, line...)
+
+		// The headers end with an empty line.
+		if len(bytes.TrimRight(line, "\r\n")) == 0 && len(line) > 0 {
+			return header, nil
+		}
+
+		if err == io.EOF {
+			// A message with no body, ensure the
+			// header-block is terminated.
+			if len(header) > 0 && header[len(header)-1] != '\n' {
+				header = append(header, '\n')
+			}
+			return append(header, '\n'), nil
+		}
+		if err != nil {
+			return header, err
+		}
+	}
+}
+
+// Upgrade parses the body of a message which was created via New,
+// allowing it to be used in the same way as one created via NewEnmime.
+//
+// The headers we've already read are reused, so only the remainder of
+// the message is read from disk.
+func (m *Email) Upgrade() error {
+
+	if m._enmime {
+		return nil
+	}
+
+	f, err := os.Open(m.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to open %s - %s", m.Filename, err.Error())
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if m.header != nil {
+		if _, err = f.Seek(int64(len(m.header)), io.SeekStart); err != nil {
+			return err
+		}
+		r = io.MultiReader(bytes.NewReader(m.header), f)
+	}
+
+	m.Enmime, err = enmime.ReadEnvelope(r)
+	if err != nil {
+		return err
+	}
+
+	m._enmime = true
+	return nil
+}
+
+// NewFromHeader creates a new mail-reading object from a set of
+// headers which have already been parsed, for example by a previous
+// call to New, without touching the file on-disk.
+//
+// As with New this allows access to the header-values, but not the
+// message-body.
+func NewFromHeader(file string, header mail.Header) *Email {
+	return &Email{Filename: file, Message: &mail.Message{Header: header}}
+}
+
 // NewEnmime creates a new mail-reading object which uses the enmime
 // library.
 //
@@ -106,34 +195,10 @@ func NewEnmime(file string) (*Email, error) {
 // Flags returns the flags from the given message, by reading them from
 // the filename.
 //
-// As a special case if the message is in the new/ folder the flag "N"
-// is also added.
+// As a special case if the message is unread, because it is in the new/
+// folder or hasn't been seen, the flag "N" is also added.
 func (m *Email) Flags() string {
-	//
-	flags := ""
-
-	// get the flags
-	i := strings.Index(m.Filename, ":2,")
-	if i > 0 {
-		flags = m.Filename[i+3:]
-	}
-
-	// Add on a fake (N)ew flag based on filename
-	if strings.Contains(m.Filename, "/new/") {
-		flags += "N"
-	}
-
-	// If the message flags do NOT have (S)een
-	// then it is also new
-	if !strings.Contains(flags, "S") && !strings.Contains(flags, "N") {
-		flags += "N"
-	}
-
-	// Sort the flags.
-	s := strings.Split(flags, "")
-	sort.Strings(s)
-
-	return strings.Join(s, "")
+	return finder.ParseState(m.Filename).String()
 }
 
 // Header returns the value of the given header from within our message.
@@ -147,7 +212,30 @@ func (m *Email) Header(name string) string {
 	}
 
 	// Get the header using the native-method.
-	value := m.Message.Header.Get(name)
+	return decodeHeader(m.Message.Header.Get(name))
+}
+
+// HeaderValues returns all the values of the given header from within
+// our message, in the order they appear, for headers such as "Received"
+// which may be present more than once.
+//
+// Header values are RFC2047-decoded.
+func (m *Email) HeaderValues(name string) []string {
+
+	if m._enmime {
+		return m.Enmime.GetHeaderValues(name)
+	}
+
+	var values []string
+	for _, value := range m.Message.Header[textproto.CanonicalMIMEHeaderKey(name)] {
+		values = append(values, decodeHeader(value))
+	}
+	return values
+}
+
+// decodeHeader returns the RFC2047-decoded form of the given header-value,
+// or the value unchanged if it can't be decoded.
+func decodeHeader(value string) string {
 
 	// GO 1.5 does not decode headers, but this may change in
 	// future releases...
@@ -158,25 +246,67 @@ func (m *Email) Header(name string) string {
 	return decoded
 }
 
-// Body returns the body of an email message, in a useful format.
-// That means that if a 'text/plain' part is present it will be
-// returned, otherwise we'll use 'text/html'.  If neither part
-// is present then the raw body will be returned.
-func (m *Email) Body() string {
+// HeaderNames returns the (canonical) names of all the headers present
+// in our message, sorted alphabetically.
+func (m *Email) HeaderNames() []string {
+
+	var names []string
 
 	if m._enmime {
-		// Try to work out what to return
-		if len(m.Enmime.Text) > 0 {
-			return m.Enmime.Text
-		} else if len(m.Enmime.HTML) > 0 {
-			return m.Enmime.HTML
+		names = m.Enmime.GetHeaderKeys()
+	} else {
+		for name := range m.Message.Header {
+			names = append(names, name)
 		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Addresses returns the parsed list of email addresses contained in the
+// given header, such as "To" or "Cc".
+//
+// Addresses are RFC2047-decoded.  A missing header results in an empty
+// list, rather than an error.
+func (m *Email) Addresses(name string) ([]*mail.Address, error) {
+
+	var list []*mail.Address
+	var err error
+
+	if m._enmime {
+		list, err = m.Enmime.AddressList(name)
+	} else {
+		list, err = m.Message.Header.AddressList(name)
+	}
 
+	if err == mail.ErrHeaderNotPresent {
+		return nil, nil
 	}
+	return list, err
+}
 
-	// At this point we're either not using the enmime
-	// library, or we are but it failed to decode something
-	// sane we can use.
+// Date returns the parsed value of the Date-header of our message.
+func (m *Email) Date() (time.Time, error) {
+	return mail.ParseDate(m.Header("Date"))
+}
+
+// Body returns the body of an email message, in a useful format.
+// That means that if a 'text/plain' part is present it will be
+// returned, otherwise we'll convert the 'text/html' part to text.
+// If neither part is present then a placeholder will be returned.
+//
+// If the message was created via New it will be upgraded.
+func (m *Email) Body() string {
+
+	text := m.BodyText()
+	if len(text) > 0 {
+		return text
+	}
+
+	// At this point we either failed to parse the message,
+	// or we did but it failed to decode something sane we
+	// can use.
 	//
 	// This probably means a message with an inline-part
 	// and no "real" text.
@@ -187,3 +317,40 @@ func (m *Email) Body() string {
 	//
 	return "No text/plain, or text/html body was available."
 }
+
+// BodyText returns the 'text/plain' part of the message, if present,
+// otherwise the 'text/html' part converted to plain-text.
+//
+// If the message was created via New it will be upgraded.
+func (m *Email) BodyText() string {
+
+	if m.Upgrade() != nil {
+		return ""
+	}
+
+	// If enmime generated the text from the HTML part then we
+	// prefer our own conversion.
+	fromHTML := m.Enmime.Text == ""
+	for _, e := range m.Enmime.Errors {
+		if e.Name == enmime.ErrorPlainTextFromHTML {
+			fromHTML = true
+		}
+	}
+
+	if fromHTML && len(m.Enmime.HTML) > 0 {
+		return htmltext.Convert(m.Enmime.HTML)
+	}
+	return m.Enmime.Text
+}
+
+// BodyHTML returns the raw 'text/html' part of the message, which
+// will be empty if there is no such part.
+//
+// If the message was created via New it will be upgraded.
+func (m *Email) BodyHTML() string {
+
+	if m.Upgrade() != nil {
+		return ""
+	}
+	return m.Enmime.HTML
+}
@@ -0,0 +1,158 @@
+package mailreader
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHeaderOnly(t *testing.T) {
+
+	dir, path := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(dir)
+
+	m, err := New(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if m.Header("Subject") != "test" {
+		t.Errorf("unexpected subject '%s'", m.Header("Subject"))
+	}
+	if string(m.header) != "Subject: test\n\n" {
+		t.Errorf("read more than the header-block: '%s'", m.header)
+	}
+
+	// Now upgrade, to get the body.
+	err = m.Upgrade()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.TrimSpace(m.Body()) != "Body" {
+		t.Errorf("unexpected body '%s'", m.Body())
+	}
+	if m.Header("Subject") != "test" {
+		t.Errorf("unexpected subject '%s'", m.Header("Subject"))
+	}
+}
+
+func TestHeaderNoBody(t *testing.T) {
+
+	for _, input := range []string{"Subject: test", "Subject: test\n", "Subject: test\r\n\r\n"} {
+
+		header, err := readHeader(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(header))
+		if err != nil {
+			t.Fatalf("failed to parse header-block '%s': %s", header, err)
+		}
+		if msg.Header.Get("Subject") != "test" {
+			t.Errorf("unexpected subject '%s'", msg.Header.Get("Subject"))
+		}
+	}
+}
+
+func TestHeaderValues(t *testing.T) {
+
+	dir, path := makeMaildir(t, "cur/1234.host:2,S")
+	defer os.RemoveAll(dir)
+
+	content := "Received: from a\nReceived: from b\nSubject: =?utf-8?q?caf=C3=A9?=\n\nBody\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	header, err := New(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	full, err := NewEnmime(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, m := range []*Email{header, full} {
+
+		received := m.HeaderValues("received")
+		if len(received) != 2 || received[0] != "from a" || received[1] != "from b" {
+			t.Errorf("unexpected Received values %v", received)
+		}
+
+		subject := m.HeaderValues("Subject")
+		if len(subject) != 1 || subject[0] != "café" {
+			t.Errorf("unexpected Subject values %v", subject)
+		}
+
+		if missing := m.HeaderValues("X-Missing"); len(missing) != 0 {
+			t.Errorf("unexpected values for a missing header %v", missing)
+		}
+	}
+}
+
+func TestAttachments(t *testing.T) {
+
+	m, err := New("testdata/attachments.eml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	attachments, err := m.Attachments()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(attachments) != 2 {
+		t.Fatalf("expected two attachments, got %d", len(attachments))
+	}
+
+	if attachments[0].Filename != "../../etc/evil.txt" || attachments[0].Inline {
+		t.Errorf("unexpected attachment %v", attachments[0])
+	}
+	if string(attachments[0].Content) != "hello world\n" || attachments[0].Size != 12 {
+		t.Errorf("unexpected content '%s'", attachments[0].Content)
+	}
+
+	if attachments[1].Filename != "élé.png" || !attachments[1].Inline || attachments[1].ContentID != "img1" {
+		t.Errorf("unexpected attachment %v", attachments[1])
+	}
+}
+
+func TestStructure(t *testing.T) {
+
+	m, err := New("testdata/attachments.eml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	root, err := m.Structure()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if root.ContentType != "multipart/mixed" || len(root.Children) != 3 {
+		t.Fatalf("unexpected root %v", root)
+	}
+
+	parts := root.Flatten()
+	if len(parts) != 4 {
+		t.Fatalf("expected four parts, got %d", len(parts))
+	}
+
+	expected := []string{"multipart/mixed", "text/plain", "application/octet-stream", "image/png"}
+	for i, part := range parts {
+		if part.ContentType != expected[i] {
+			t.Errorf("part %d has type %s, expected %s", i, part.ContentType, expected[i])
+		}
+	}
+
+	if parts[2].Encoding != "base64" || parts[2].Disposition != "attachment" || parts[2].Size != 12 {
+		t.Errorf("unexpected part %v", parts[2])
+	}
+	if root.Size != 23 {
+		t.Errorf("unexpected total size %d", root.Size)
+	}
+}
@@ -0,0 +1,98 @@
+package mailreader
+
+import (
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+)
+
+// Part holds the details of a single part of the MIME structure of a
+// message.
+type Part struct {
+
+	// ID holds the position of this part within the tree, such as
+	// "1.2" for the second child of the first part.
+	ID string
+
+	// ContentType holds the MIME-type of the part.
+	ContentType string
+
+	// Charset holds the character-set the part was encoded with.
+	Charset string
+
+	// Encoding holds the Content-Transfer-Encoding of the part.
+	Encoding string
+
+	// Disposition holds the Content-Disposition of the part, which
+	// will be empty, "inline", or "attachment".
+	Disposition string
+
+	// Filename holds the (decoded) filename of the part, if any.
+	Filename string
+
+	// Size holds the decoded size of the part, in bytes.  For
+	// multipart containers this is the total size of the children.
+	Size int
+
+	// Children holds the sub-parts of a multipart container.
+	Children []*Part
+}
+
+// Structure returns the root of the tree of MIME parts which make up
+// the message.
+//
+// Accessing the structure requires the message-body to be parsed, so if
+// the message was created via New it will be upgraded.
+func (m *Email) Structure() (*Part, error) {
+
+	if err := m.Upgrade(); err != nil {
+		return nil, err
+	}
+
+	return newPart(m.Enmime.Root), nil
+}
+
+// newPart converts an enmime part, and its children, into our own
+// representation.
+func newPart(p *enmime.Part) *Part {
+
+	part := &Part{
+		ID:          p.PartID,
+		ContentType: p.ContentType,
+		Charset:     p.OrigCharset,
+		Encoding:    strings.ToLower(p.Header.Get("Content-Transfer-Encoding")),
+		Disposition: p.Disposition,
+		Filename:    p.FileName,
+		Size:        len(p.Content),
+	}
+
+	// Apply the defaults from RFC2045.
+	if part.ContentType == "" {
+		part.ContentType = "text/plain"
+	}
+	if part.Charset == "" {
+		part.Charset = p.Charset
+	}
+	if part.Encoding == "" {
+		part.Encoding = "7bit"
+	}
+
+	for c := p.FirstChild; c != nil; c = c.NextSibling {
+		child := newPart(c)
+		part.Children = append(part.Children, child)
+		part.Size += child.Size
+	}
+
+	return part
+}
+
+// Flatten returns this part, and all its descendants, in depth-first
+// order.
+func (p *Part) Flatten() []*Part {
+
+	ret := []*Part{p}
+	for _, c := range p.Children {
+		ret = append(ret, c.Flatten()...)
+	}
+	return ret
+}
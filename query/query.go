@@ -0,0 +1,523 @@
+// Package query implements a simple query-language for searching email
+// messages.
+//
+// A query is made up of terms, which may be combined with the boolean
+// operators AND, OR, and NOT, and grouped with parentheses.  Terms which
+// are next to each other are implicitly joined with AND, and a term may
+// be negated with a leading "-".
+//
+// Terms look like this:
+//
+//   word            - Matches the Subject, From, To, or Cc headers.
+//   from:steve      - Matches the From header, and similarly for
+//                     to:, cc:, bcc:, and subject:.
+//   body:invoice    - Matches the body of the message.
+//   x-mailer:mutt   - Matches any other named header.
+//   flag:FS         - Matches messages with all of the given flags.
+//   is:unread       - Matches messages by state: unread, new, read, seen,
+//                     flagged, replied, passed, trashed, or draft.
+//   date:2020-01    - Matches messages sent within the given day, month,
+//                     or year.
+//   date:A..B       - Matches messages sent between the two dates, either
+//                     of which may be omitted.
+//   before:DATE     - Matches messages sent before the given date.
+//   after:DATE      - Matches messages sent after the given date.
+//
+// Text-matches are case-insensitive substring matches, unless the value
+// is surrounded by slashes, in which case it is treated as a regular
+// expression.  Values containing spaces may be quoted.
+//
+// For example:
+//
+//   from:steve (subject:"weekly report" OR body:"/invoice #[0-9]+/") -is:read
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Message is the interface which must be implemented by the messages we
+// test our queries against.
+type Message interface {
+
+	// Header returns the (decoded) value of the named header.
+	Header(name string) string
+
+	// Flags returns the flags of the message.
+	Flags() string
+
+	// Date returns the parsed Date-header of the message.
+	Date() (time.Time, error)
+
+	// Body returns the text of the message.
+	Body() string
+}
+
+// Query is a parsed query, which can be matched against messages.
+type Query struct {
+
+	// root is the top-level node of our expression-tree.
+	root node
+}
+
+// node is a single node in our expression-tree.
+type node interface {
+	match(m Message) bool
+}
+
+// Parse parses the given query-string.
+func Parse(input string) (*Query, error) {
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	// An empty query matches everything.
+	if len(tokens) == 0 {
+		return &Query{root: all{}}, nil
+	}
+
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in query", p.tokens[p.pos].text)
+	}
+
+	return &Query{root: root}, nil
+}
+
+// Match returns true if the given message matches our query.
+func (q *Query) Match(m Message) bool {
+	return q.root.match(m)
+}
+
+//
+// Tokenizing
+//
+
+// token is a single token from our input.
+type token struct {
+
+	// text holds the text of the token, with any quotes removed.
+	text string
+
+	// quoted is true if the token started with a quote, which
+	// stops it being treated as an operator.
+	quoted bool
+}
+
+// tokenize splits the input into tokens.
+//
+// Tokens are separated by whitespace, except within quotes, and
+// parentheses are always tokens of their own.
+func tokenize(input string) ([]token, error) {
+
+	var tokens []token
+	var cur token
+	inQuote := false
+	started := false
+
+	flush := func() {
+		if started {
+			tokens = append(tokens, cur)
+		}
+		cur = token{}
+		started = false
+	}
+
+	for _, c := range input {
+
+		switch {
+		case c == '"':
+			if !started {
+				cur.quoted = true
+			}
+			inQuote = !inQuote
+			started = true
+		case inQuote:
+			cur.text += string(c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		case (c == '(' || c == ')') && !started:
+			tokens = append(tokens, token{text: string(c)})
+		case c == '(' && cur.text == "-" && !cur.quoted:
+			// Negation of a group, "-(...)".
+			tokens = append(tokens, token{text: "NOT"}, token{text: "("})
+			cur = token{}
+			started = false
+		case c == ')':
+			flush()
+			tokens = append(tokens, token{text: string(c)})
+		default:
+			cur.text += string(c)
+			started = true
+		}
+	}
+
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in query")
+	}
+	flush()
+
+	return tokens, nil
+}
+
+//
+// Parsing
+//
+
+// parser holds our parsing state.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the next token, if it is an unquoted operator, or
+// parenthesis.
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+// or parses: and ("OR" and)*
+func (p *parser) or() (node, error) {
+
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "OR" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left, right}
+	}
+
+	return left, nil
+}
+
+// and parses: unary (["AND"] unary)*
+func (p *parser) and() (node, error) {
+
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.pos < len(p.tokens) {
+
+		next := p.peek()
+		if next == "OR" || next == ")" {
+			break
+		}
+		if next == "AND" {
+			p.pos++
+		}
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left, right}
+	}
+
+	return left, nil
+}
+
+// unary parses: ("NOT" | "-") unary | "(" or ")" | term
+func (p *parser) unary() (node, error) {
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	next := p.peek()
+
+	switch {
+	case next == "NOT":
+		p.pos++
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{n}, nil
+
+	case next == "(":
+		p.pos++
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')' in query")
+		}
+		p.pos++
+		return n, nil
+
+	case next == ")" || next == "AND" || next == "OR":
+		return nil, fmt.Errorf("unexpected '%s' in query", next)
+
+	case len(next) > 1 && next[0] == '-':
+		// Negation, via a leading "-".
+		tok := p.tokens[p.pos]
+		p.pos++
+		n, err := term(tok.text[1:])
+		if err != nil {
+			return nil, err
+		}
+		return not{n}, nil
+	}
+
+	tok := p.tokens[p.pos]
+	p.pos++
+	return term(tok.text)
+}
+
+var (
+	// field matches the field-name of a term.
+	field = regexp.MustCompile("^([A-Za-z0-9_-]+):(.*)$")
+)
+
+// term parses a single term.
+func term(text string) (node, error) {
+
+	m := field.FindStringSubmatch(text)
+	if len(m) == 0 {
+		t, err := newText(text)
+		if err != nil {
+			return nil, err
+		}
+		return headers{names: []string{"Subject", "From", "To", "Cc"}, text: t}, nil
+	}
+
+	name := strings.ToLower(m[1])
+	value := m[2]
+
+	switch name {
+	case "body":
+		t, err := newText(value)
+		if err != nil {
+			return nil, err
+		}
+		return body{t}, nil
+
+	case "flag", "flags":
+		return flags{strings.ToUpper(value)}, nil
+
+	case "is":
+		return newState(value)
+
+	case "date":
+		return newDate(value)
+
+	case "before":
+		start, _, err := parseDate(value)
+		if err != nil {
+			return nil, err
+		}
+		return date{end: start}, nil
+
+	case "after":
+		_, end, err := parseDate(value)
+		if err != nil {
+			return nil, err
+		}
+		return date{start: end}, nil
+	}
+
+	t, err := newText(value)
+	if err != nil {
+		return nil, err
+	}
+	return headers{names: []string{m[1]}, text: t}, nil
+}
+
+//
+// Nodes
+//
+
+// all matches every message.
+type all struct{}
+
+func (all) match(m Message) bool { return true }
+
+// and matches if both of its children match.
+type and struct{ left, right node }
+
+func (n and) match(m Message) bool { return n.left.match(m) && n.right.match(m) }
+
+// or matches if either of its children match.
+type or struct{ left, right node }
+
+func (n or) match(m Message) bool { return n.left.match(m) || n.right.match(m) }
+
+// not matches if its child doesn't.
+type not struct{ child node }
+
+func (n not) match(m Message) bool { return !n.child.match(m) }
+
+// text matches a string, either literally or via a regular expression.
+type text struct {
+	literal string
+	re      *regexp.Regexp
+}
+
+// newText creates a new text-matcher.
+func newText(value string) (text, error) {
+
+	if len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		re, err := regexp.Compile("(?i)" + value[1:len(value)-1])
+		if err != nil {
+			return text{}, err
+		}
+		return text{re: re}, nil
+	}
+
+	return text{literal: strings.ToLower(value)}, nil
+}
+
+// matches returns true if the given input matches.
+func (t text) matches(input string) bool {
+	if t.re != nil {
+		return t.re.MatchString(input)
+	}
+	return strings.Contains(strings.ToLower(input), t.literal)
+}
+
+// headers matches if any of the named headers match.
+type headers struct {
+	names []string
+	text  text
+}
+
+func (n headers) match(m Message) bool {
+	for _, name := range n.names {
+		if n.text.matches(m.Header(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// body matches the body of the message.
+type body struct{ text text }
+
+func (n body) match(m Message) bool { return n.text.matches(m.Body()) }
+
+// flags matches if the message has all of the given flags.
+type flags struct{ flags string }
+
+func (n flags) match(m Message) bool {
+	have := m.Flags()
+	for _, c := range n.flags {
+		if !strings.ContainsRune(have, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// newState creates a node for an "is:" term.
+func newState(state string) (node, error) {
+
+	switch strings.ToLower(state) {
+	case "unread", "new":
+		return flags{"N"}, nil
+	case "read", "seen":
+		return not{flags{"N"}}, nil
+	case "flagged":
+		return flags{"F"}, nil
+	case "replied":
+		return flags{"R"}, nil
+	case "passed", "forwarded":
+		return flags{"P"}, nil
+	case "trashed", "deleted":
+		return flags{"T"}, nil
+	case "draft":
+		return flags{"D"}, nil
+	}
+
+	return nil, fmt.Errorf("unknown state 'is:%s'", state)
+}
+
+// date matches messages sent within a range of times.
+//
+// A zero start, or end, leaves that side of the range open.
+type date struct {
+	start time.Time
+	end   time.Time
+}
+
+func (n date) match(m Message) bool {
+
+	t, err := m.Date()
+	if err != nil {
+		return false
+	}
+	if !n.start.IsZero() && t.Before(n.start) {
+		return false
+	}
+	if !n.end.IsZero() && !t.Before(n.end) {
+		return false
+	}
+	return true
+}
+
+// newDate creates a node for a "date:" term, which is either a single
+// date or a range of the form "A..B".
+func newDate(value string) (node, error) {
+
+	parts := strings.SplitN(value, "..", 2)
+
+	start, end, err := parseDate(parts[0])
+	if err != nil && parts[0] != "" {
+		return nil, err
+	}
+
+	if len(parts) == 2 {
+		if parts[1] == "" {
+			end = time.Time{}
+		} else {
+			_, end, err = parseDate(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if parts[0] == "" {
+		return nil, fmt.Errorf("empty date in query")
+	}
+
+	return date{start: start, end: end}, nil
+}
+
+// parseDate parses a date, which may be a day, month, or year, and
+// returns the start of that period and the start of the next.
+//
+// Dates are interpreted in local-time.
+func parseDate(value string) (time.Time, time.Time, error) {
+
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.ParseInLocation("2006-01", value, time.Local); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006", value, time.Local); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD, YYYY-MM, or YYYY", value)
+}
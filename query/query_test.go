@@ -0,0 +1,114 @@
+package query
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMessage is a simple implementation of our Message interface.
+type testMessage struct {
+	headers map[string]string
+	flags   string
+	date    string
+	body    string
+}
+
+func (t testMessage) Header(name string) string { return t.headers[strings.ToLower(name)] }
+func (t testMessage) Flags() string             { return t.flags }
+func (t testMessage) Body() string              { return t.body }
+func (t testMessage) Date() (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04", t.date, time.Local)
+}
+
+func TestQuery(t *testing.T) {
+
+	msg := testMessage{
+		headers: map[string]string{
+			"from":     "Steve Kemp <steve@example.com>",
+			"to":       "Bob <bob@example.com>",
+			"subject":  "Weekly report, 2020",
+			"x-mailer": "mutt",
+		},
+		flags: "FS",
+		date:  "2020-01-15 12:00",
+		body:  "Please pay invoice #1234.",
+	}
+
+	type TestCase struct {
+		Query string
+		Match bool
+	}
+
+	tests := []TestCase{
+		{"", true},
+		{"weekly", true},
+		{"monthly", false},
+		{"from:steve", true},
+		{"from:STEVE", true},
+		{"to:steve", false},
+		{"subject:\"weekly report\"", true},
+		{"subject:\"report weekly\"", false},
+		{"x-mailer:mutt", true},
+		{"body:invoice", true},
+		{"body:\"/invoice #[0-9]+/\"", true},
+		{"body:/invoice.#[a-z]+/", false},
+		{"flag:F", true},
+		{"flag:FS", true},
+		{"flag:R", false},
+		{"is:flagged", true},
+		{"is:unread", false},
+		{"is:read", true},
+		{"-is:read", false},
+		{"NOT is:read", false},
+		{"date:2020", true},
+		{"date:2020-01", true},
+		{"date:2020-01-15", true},
+		{"date:2020-02", false},
+		{"date:2020-01-01..2020-01-31", true},
+		{"date:2020-01-16..", false},
+		{"date:..2020-01-15", true},
+		{"before:2020-01-15", false},
+		{"before:2020-01-16", true},
+		{"after:2020-01-14", true},
+		{"after:2020-01-15", false},
+		{"from:steve to:bob", true},
+		{"from:steve AND to:alice", false},
+		{"from:alice OR to:bob", true},
+		{"from:alice OR (to:bob is:unread)", false},
+		{"from:alice OR -(to:bob is:unread)", true},
+		{"\"AND\"", false},
+	}
+
+	for _, test := range tests {
+		q, err := Parse(test.Query)
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %s", test.Query, err)
+			continue
+		}
+		if q.Match(msg) != test.Match {
+			t.Errorf("query '%s' returned %v, expected %v", test.Query, !test.Match, test.Match)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	tests := []string{
+		"(from:steve",
+		"from:steve)",
+		"subject:\"unterminated",
+		"OR from:steve",
+		"from:steve AND",
+		"is:sleepy",
+		"date:yesterday",
+		"body:/[/",
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test)
+		if err == nil {
+			t.Errorf("expected error parsing '%s'", test)
+		}
+	}
+}
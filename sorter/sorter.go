@@ -0,0 +1,206 @@
+// Package sorter sorts lists of email messages.
+//
+// Messages may be sorted by one of the following keys, each of which
+// may be prefixed with "reverse-" to reverse the order:
+//
+//   mtime    - The modification-time of the message-file.
+//   date     - The Date-header of the message.
+//   arrival  - The delivery-time, from the Maildir filename.
+//   from     - The name, or address, of the sender.
+//   subject  - The subject, ignoring any "Re:" or "Fwd:" prefixes.
+//   size     - The size of the message-file.
+//   flags    - The flags, with unread and flagged messages first.
+//
+// Sorting is stable, and messages which compare equal remain in the
+// order they were supplied.
+package sorter
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skx/maildir-tools/finder"
+	"github.com/skx/maildir-tools/thread"
+)
+
+// Keys holds the names of the keys we can sort by, in the order they
+// should be cycled through.
+var Keys = []string{"mtime", "date", "arrival", "from", "subject", "size", "flags"}
+
+// Reverse is the prefix used to reverse a sort-order.
+const Reverse = "reverse-"
+
+// Message is the interface which must be implemented by the messages we
+// sort.
+type Message interface {
+
+	// Header returns the (decoded) value of the named header.
+	Header(name string) string
+
+	// Flags returns the flags of the message.
+	Flags() string
+
+	// Date returns the parsed Date-header of the message.
+	Date() (time.Time, error)
+}
+
+// Valid returns an error if the given sort-order is not recognized.
+//
+// The empty string is valid, and means the default "mtime" order.
+func Valid(order string) error {
+
+	if order == "" {
+		return nil
+	}
+
+	key := strings.TrimPrefix(order, Reverse)
+
+	for _, k := range Keys {
+		if k == key {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown sort-order '%s', valid orders are: %s", order, strings.Join(Keys, ", "))
+}
+
+// Next returns the order which follows the given one, cycling through
+// each of our keys while keeping the direction unchanged.
+func Next(order string) string {
+
+	prefix := ""
+	if strings.HasPrefix(order, Reverse) {
+		prefix = Reverse
+	}
+
+	key := strings.TrimPrefix(order, Reverse)
+	if key == "" {
+		key = Keys[0]
+	}
+
+	for i, k := range Keys {
+		if k == key {
+			return prefix + Keys[(i+1)%len(Keys)]
+		}
+	}
+
+	return prefix + Keys[0]
+}
+
+// Toggle returns the given sort-order with its direction reversed.
+func Toggle(order string) string {
+
+	if strings.HasPrefix(order, Reverse) {
+		return strings.TrimPrefix(order, Reverse)
+	}
+	if order == "" {
+		order = Keys[0]
+	}
+	return Reverse + order
+}
+
+// Sort returns the order in which the given messages should be shown,
+// as a list of indexes into the messages.
+//
+// The files must hold the on-disk details of each message, in the same
+// order as the messages themselves.
+func Sort(order string, messages []Message, files []finder.MessageFile) ([]int, error) {
+
+	if err := Valid(order); err != nil {
+		return nil, err
+	}
+
+	reverse := strings.HasPrefix(order, Reverse)
+	key := strings.TrimPrefix(order, Reverse)
+
+	// Calculate the values we're comparing once, up-front,
+	// since some of them require parsing.
+	times := make([]time.Time, len(messages))
+	texts := make([]string, len(messages))
+	numbers := make([]int64, len(messages))
+
+	for i, msg := range messages {
+		switch key {
+		case "", "mtime":
+			times[i] = files[i].ModTime
+		case "date":
+			t, err := msg.Date()
+			if err != nil {
+				t = files[i].ModTime
+			}
+			times[i] = t
+		case "arrival":
+			times[i] = files[i].Arrival()
+		case "from":
+			texts[i] = sender(msg.Header("From"))
+		case "subject":
+			texts[i] = thread.BaseSubject(msg.Header("Subject"))
+		case "size":
+			numbers[i] = files[i].Size
+		case "flags":
+			texts[i] = msg.Flags()
+			numbers[i] = flagRank(texts[i])
+		}
+	}
+
+	less := func(a, b int) bool {
+		switch key {
+		case "", "mtime", "date", "arrival":
+			return times[a].Before(times[b])
+		case "from", "subject":
+			return texts[a] < texts[b]
+		case "size":
+			return numbers[a] < numbers[b]
+		case "flags":
+			if numbers[a] != numbers[b] {
+				return numbers[a] < numbers[b]
+			}
+			return texts[a] < texts[b]
+		}
+		return false
+	}
+
+	indexes := make([]int, len(messages))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		if reverse {
+			return less(indexes[j], indexes[i])
+		}
+		return less(indexes[i], indexes[j])
+	})
+
+	return indexes, nil
+}
+
+// sender returns the name of the sender from the given From-header, or
+// their address if there is no name, in lower-case.
+func sender(from string) string {
+
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(from))
+	}
+	if addr.Name != "" {
+		return strings.ToLower(addr.Name)
+	}
+	return strings.ToLower(addr.Address)
+}
+
+// flagRank returns the rank of a message by its flags, so that unread
+// messages sort before flagged messages, which sort before the rest.
+func flagRank(flags string) int64 {
+
+	switch {
+	case strings.Contains(flags, "N"):
+		return 0
+	case strings.Contains(flags, "F"):
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,107 @@
+package sorter
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/maildir-tools/finder"
+)
+
+// testMessage is a simple implementation of our Message interface.
+type testMessage struct {
+	headers map[string]string
+	flags   string
+}
+
+func (t testMessage) Header(name string) string { return t.headers[strings.ToLower(name)] }
+func (t testMessage) Flags() string             { return t.flags }
+func (t testMessage) Date() (time.Time, error)  { return mail.ParseDate(t.headers["date"]) }
+
+func TestSort(t *testing.T) {
+
+	messages := []Message{
+		testMessage{headers: map[string]string{
+			"from":    "Steve <steve@example.com>",
+			"subject": "Re: beta",
+			"date":    "Wed, 1 Jan 2020 12:00:00 +0000",
+		}, flags: "S"},
+		testMessage{headers: map[string]string{
+			"from":    "alice@example.com",
+			"subject": "gamma",
+			"date":    "Mon, 1 Jan 2018 12:00:00 +0000",
+		}, flags: "FS"},
+		testMessage{headers: map[string]string{
+			"from":    "\"Bob\" <zed@example.com>",
+			"subject": "Alpha",
+			"date":    "broken",
+		}, flags: "N"},
+	}
+
+	files := []finder.MessageFile{
+		{Path: "/tmp/cur/1500000000.M1P1.host:2,S", ModTime: time.Unix(100, 0), Size: 300},
+		{Path: "/tmp/cur/1400000000.M1P1.host:2,FS", ModTime: time.Unix(200, 0), Size: 100},
+		{Path: "/tmp/new/1600000000.M1P1.host", ModTime: time.Unix(50, 0), Size: 200},
+	}
+
+	type TestCase struct {
+		Order    string
+		Expected string
+	}
+
+	tests := []TestCase{
+		{"", "[0 1 2]"},
+		{"mtime", "[2 0 1]"},
+		{"reverse-mtime", "[1 0 2]"},
+		// The broken date falls back to the mtime.
+		{"date", "[2 1 0]"},
+		{"arrival", "[1 0 2]"},
+		{"from", "[1 2 0]"},
+		{"subject", "[2 0 1]"},
+		{"reverse-subject", "[1 0 2]"},
+		{"size", "[1 2 0]"},
+		{"flags", "[2 1 0]"},
+	}
+
+	for _, tst := range tests {
+
+		// Files are supplied in mtime-order by the finder, so the
+		// default order doesn't change anything.
+		input := messages
+		inputFiles := files
+		if tst.Order == "" {
+			input = []Message{messages[2], messages[0], messages[1]}
+			inputFiles = []finder.MessageFile{files[2], files[0], files[1]}
+		}
+
+		out, err := Sort(tst.Order, input, inputFiles)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", tst.Order, err)
+		}
+		if fmt.Sprintf("%v", out) != tst.Expected {
+			t.Errorf("sorting by '%s' expected %s, got %v", tst.Order, tst.Expected, out)
+		}
+	}
+}
+
+func TestOrders(t *testing.T) {
+
+	if Valid("bogus") == nil {
+		t.Errorf("expected an error for an unknown order")
+	}
+	if Valid("reverse-date") != nil {
+		t.Errorf("unexpected error for a valid order")
+	}
+	if _, err := Sort("reverse-bogus", nil, nil); err == nil {
+		t.Errorf("expected an error sorting with an unknown order")
+	}
+
+	if Next("mtime") != "date" || Next("reverse-flags") != "reverse-mtime" || Next("") != "date" {
+		t.Errorf("cycling sort-orders failed")
+	}
+	if Toggle("date") != "reverse-date" || Toggle("reverse-date") != "date" || Toggle("") != "reverse-mtime" {
+		t.Errorf("reversing sort-orders failed")
+	}
+}
@@ -0,0 +1,433 @@
+// Package thread groups email messages into conversations.
+//
+// The implementation follows Jamie Zawinski's threading algorithm, as
+// described at https://www.jwz.org/doc/threading.html, which is used by
+// many mail-clients.  Messages are linked together via their Message-ID,
+// In-Reply-To, and References headers, and messages whose parents are
+// missing are grouped by their subjects.
+package thread
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// messageID matches a single message-ID within a header.
+	messageID = regexp.MustCompile("<[^<>]+>")
+
+	// replyPrefix matches the prefixes added to subjects by replies,
+	// and forwards, such as "Re:", "Fwd:", and "Re[2]:".
+	replyPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|sv)(\[[0-9]+\])?\s*:\s*`)
+)
+
+// Message is the interface which must be implemented by the messages we
+// thread.
+type Message interface {
+
+	// Header returns the (decoded) value of the named header.
+	Header(name string) string
+}
+
+// Node is a single node in a thread-tree.
+type Node struct {
+
+	// Index holds the index of the message in the list supplied
+	// to Thread, or -1 if this is a placeholder for a message we
+	// haven't seen.
+	Index int
+
+	// Parent holds the parent of this node, if any.
+	Parent *Node
+
+	// Children holds the replies to this message.
+	Children []*Node
+
+	// first holds the lowest index in this subtree, used for sorting.
+	first int
+}
+
+// Entry holds the details of a single message in a flattened thread.
+type Entry struct {
+
+	// Index holds the index of the message in the list supplied
+	// to Thread.
+	Index int
+
+	// Thread holds the number of the thread this message belongs to,
+	// starting from zero.
+	Thread int
+
+	// Depth holds the depth of the message in its thread, zero for
+	// the first message.
+	Depth int
+
+	// Count holds the total number of messages in the thread.
+	Count int
+
+	// Tree holds an ASCII-art prefix which draws the branches of the
+	// thread, for example "| `->".
+	Tree string
+}
+
+// Thread groups the given messages into conversations, returning the
+// root node of each thread.
+//
+// Threads are sorted by the index of their first message, as are the
+// replies to each message, so the order of the input is preserved as
+// far as possible.
+func Thread(messages []Message) []*Node {
+
+	// Containers, indexed by message-ID.
+	ids := make(map[string]*Node)
+
+	// Containers, in the order they were created, so that our output
+	// doesn't depend upon the order of iterating over a map.
+	var order []*Node
+
+	container := func(id string) *Node {
+		n, ok := ids[id]
+		if !ok {
+			n = &Node{Index: -1}
+			ids[id] = n
+			order = append(order, n)
+		}
+		return n
+	}
+
+	// Step one: create containers, and link them via references.
+	for i, msg := range messages {
+
+		id := normalizeID(msg.Header("Message-ID"))
+
+		// Messages without IDs, or with duplicate IDs, get a
+		// unique one generated for them.
+		if id == "" || (ids[id] != nil && ids[id].Index != -1) {
+			id = fmt.Sprintf("<generated-%d@maildir-tools>", i)
+		}
+
+		node := container(id)
+		node.Index = i
+
+		// The references, from the root of the thread to our parent.
+		refs := messageID.FindAllString(msg.Header("References"), -1)
+		reply := messageID.FindAllString(msg.Header("In-Reply-To"), -1)
+		if len(reply) > 0 && (len(refs) == 0 || refs[len(refs)-1] != reply[0]) {
+			refs = append(refs, reply[0])
+		}
+
+		// Link each reference to the next, unless already linked.
+		var prev *Node
+		for _, ref := range refs {
+			cur := container(ref)
+			if prev != nil && cur.Parent == nil && cur != prev && !reachable(cur, prev) {
+				link(prev, cur)
+			}
+			prev = cur
+		}
+
+		// Our parent is the last reference, which overrides any
+		// parent we might have been given by other messages.
+		if prev != nil && prev != node && !reachable(node, prev) {
+			unlink(node)
+			link(prev, node)
+		}
+	}
+
+	// Step two: find the root set.
+	var roots []*Node
+	for _, n := range order {
+		if n.Parent == nil {
+			roots = append(roots, n)
+		}
+	}
+
+	// Step four: prune placeholders.
+	roots = prune(roots, true)
+
+	// Step five: group the root set by subject.
+	roots = groupBySubject(roots, messages)
+
+	// Finally sort things.
+	sortNodes(roots)
+
+	return roots
+}
+
+// normalizeID returns the first message-ID in the given header, or the
+// trimmed header if it doesn't contain any angle-brackets.
+func normalizeID(header string) string {
+
+	id := messageID.FindString(header)
+	if id == "" {
+		id = strings.TrimSpace(header)
+	}
+	return id
+}
+
+// reachable returns true if the target node is the given node, or one of
+// its descendants.
+func reachable(n *Node, target *Node) bool {
+
+	if n == target {
+		return true
+	}
+	for _, c := range n.Children {
+		if reachable(c, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// link makes child a child of parent.
+func link(parent *Node, child *Node) {
+	child.Parent = parent
+	parent.Children = append(parent.Children, child)
+}
+
+// unlink removes the given node from its parent, if any.
+func unlink(n *Node) {
+
+	if n.Parent == nil {
+		return
+	}
+
+	p := n.Parent
+	for i, c := range p.Children {
+		if c == n {
+			p.Children = append(p.Children[:i], p.Children[i+1:]...)
+			break
+		}
+	}
+	n.Parent = nil
+}
+
+// prune removes placeholder nodes which have no children, and replaces
+// placeholders with their children - except at the root-level, where a
+// placeholder with several children is kept to group them together.
+func prune(nodes []*Node, root bool) []*Node {
+
+	var ret []*Node
+
+	for _, n := range nodes {
+
+		n.Children = prune(n.Children, false)
+
+		if n.Index != -1 {
+			ret = append(ret, n)
+			continue
+		}
+
+		// An empty placeholder is removed.
+		if len(n.Children) == 0 {
+			continue
+		}
+
+		// A placeholder at the root with several children is kept.
+		if root && len(n.Children) > 1 {
+			ret = append(ret, n)
+			continue
+		}
+
+		// Otherwise we promote the children.
+		for _, c := range n.Children {
+			c.Parent = n.Parent
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
+// normalizeSubject returns the subject with any reply-prefixes removed,
+// and a flag to show if any were present.
+func normalizeSubject(subject string) (string, bool) {
+
+	reply := false
+	for replyPrefix.MatchString(subject) {
+		subject = replyPrefix.ReplaceAllString(subject, "")
+		reply = true
+	}
+	return strings.ToLower(strings.TrimSpace(subject)), reply
+}
+
+// BaseSubject returns the given subject with any prefixes added by
+// replies, or forwards, removed, in lower-case.
+//
+// This is the subject we use to group messages without references.
+func BaseSubject(subject string) string {
+	base, _ := normalizeSubject(subject)
+	return base
+}
+
+// subject returns the subject of the given node, or its first child
+// if it is a placeholder.
+func subject(n *Node, messages []Message) (string, bool) {
+
+	if n.Index == -1 {
+		if len(n.Children) == 0 {
+			return "", false
+		}
+		n = n.Children[0]
+	}
+	return normalizeSubject(messages[n.Index].Header("Subject"))
+}
+
+// groupBySubject merges threads in the root set which share the same
+// subject, as happens when a client doesn't set References.
+func groupBySubject(roots []*Node, messages []Message) []*Node {
+
+	// Find the best root for each subject.
+	//
+	// A placeholder is preferred, then a message which is not a reply.
+	subjects := make(map[string]*Node)
+	for _, n := range roots {
+
+		subj, reply := subject(n, messages)
+		if subj == "" {
+			continue
+		}
+
+		old, ok := subjects[subj]
+		if !ok {
+			subjects[subj] = n
+			continue
+		}
+
+		_, oldReply := subject(old, messages)
+		if (old.Index != -1 && n.Index == -1) || (oldReply && !reply) {
+			subjects[subj] = n
+		}
+	}
+
+	for _, n := range roots {
+
+		subj, reply := subject(n, messages)
+		best, ok := subjects[subj]
+		if subj == "" || !ok || best == n {
+			continue
+		}
+
+		_, bestReply := subject(best, messages)
+
+		switch {
+		case best.Index == -1 && n.Index == -1:
+			// Both placeholders: merge the children.
+			for _, c := range n.Children {
+				link(best, c)
+			}
+			n.Children = nil
+		case best.Index == -1:
+			// Make this message a child of the placeholder.
+			link(best, n)
+		case !bestReply && reply:
+			// This is a reply to the best message.
+			link(best, n)
+		case best.Parent != nil:
+			// The best message has already been grouped with
+			// another beneath a placeholder, so join them.
+			link(best.Parent, n)
+		default:
+			// Neither is a reply to the other, so group them
+			// beneath a new placeholder.
+			holder := &Node{Index: -1}
+			link(holder, best)
+			link(holder, n)
+		}
+	}
+
+	// The new root set is the top of each tree, ignoring the
+	// placeholders we emptied.
+	var ret []*Node
+	seen := make(map[*Node]bool)
+	for _, n := range roots {
+		for n.Parent != nil {
+			n = n.Parent
+		}
+		if seen[n] || (n.Index == -1 && len(n.Children) == 0) {
+			continue
+		}
+		seen[n] = true
+		ret = append(ret, n)
+	}
+
+	return ret
+}
+
+// sortNodes sorts the given nodes, and their descendants, by the lowest
+// message-index they contain.
+func sortNodes(nodes []*Node) {
+
+	for _, n := range nodes {
+		sortNodes(n.Children)
+
+		n.first = n.Index
+		for _, c := range n.Children {
+			if n.first == -1 || c.first < n.first {
+				n.first = c.first
+			}
+		}
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].first < nodes[j].first
+	})
+}
+
+// count returns the number of messages in the given subtree.
+func count(n *Node) int {
+
+	total := 0
+	if n.Index != -1 {
+		total++
+	}
+	for _, c := range n.Children {
+		total += count(c)
+	}
+	return total
+}
+
+// Flatten returns the messages in the given threads in display-order,
+// with the details required to draw a thread-tree.
+//
+// Placeholders aren't returned, but their children are drawn as if they
+// were siblings of a single root.
+func Flatten(roots []*Node) []Entry {
+
+	var ret []Entry
+
+	var walk func(n *Node, thread int, total int, depth int, prefix string, last bool)
+	walk = func(n *Node, thread int, total int, depth int, prefix string, last bool) {
+
+		childPrefix := prefix
+		if n.Index != -1 {
+
+			tree := ""
+			if depth > 0 {
+				if last {
+					tree = prefix + "`->"
+					childPrefix = prefix + "  "
+				} else {
+					tree = prefix + "|->"
+					childPrefix = prefix + "| "
+				}
+			}
+
+			ret = append(ret, Entry{Index: n.Index, Thread: thread, Depth: depth, Count: total, Tree: tree})
+			depth++
+		}
+
+		for i, c := range n.Children {
+			walk(c, thread, total, depth, childPrefix, i == len(n.Children)-1)
+		}
+	}
+
+	for i, root := range roots {
+		walk(root, i, count(root), 0, "", true)
+	}
+
+	return ret
+}
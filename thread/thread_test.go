@@ -0,0 +1,176 @@
+package thread
+
+import (
+	"strings"
+	"testing"
+)
+
+// testMessage is a simple implementation of our Message interface.
+type testMessage map[string]string
+
+func (t testMessage) Header(name string) string { return t[strings.ToLower(name)] }
+
+// render threads the given messages and returns one line per message,
+// holding the tree-prefix, subject, and count.
+func render(messages []testMessage) string {
+
+	var input []Message
+	for _, m := range messages {
+		input = append(input, m)
+	}
+
+	var out []string
+	for _, e := range Flatten(Thread(input)) {
+		out = append(out, e.Tree+messages[e.Index]["subject"])
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestThread(t *testing.T) {
+
+	type TestCase struct {
+		Name     string
+		Messages []testMessage
+		Expected string
+	}
+
+	tests := []TestCase{
+		{"unrelated",
+			[]testMessage{
+				{"message-id": "<1@x>", "subject": "one"},
+				{"message-id": "<2@x>", "subject": "two"},
+			},
+			"one\ntwo"},
+
+		{"in-reply-to",
+			[]testMessage{
+				{"message-id": "<1@x>", "subject": "one"},
+				{"message-id": "<2@x>", "subject": "two"},
+				{"message-id": "<3@x>", "subject": "Re: one", "in-reply-to": "<1@x>"},
+			},
+			"one\n`->Re: one\ntwo"},
+
+		{"references",
+			[]testMessage{
+				{"message-id": "<1@x>", "subject": "a"},
+				{"message-id": "<2@x>", "subject": "b", "references": "<1@x>"},
+				{"message-id": "<3@x>", "subject": "c", "references": "<1@x> <2@x>"},
+				{"message-id": "<4@x>", "subject": "d", "references": "<1@x>"},
+			},
+			"a\n|->b\n| `->c\n`->d"},
+
+		{"missing parent",
+			// The parent is referenced, but absent, so the children
+			// are grouped beneath it and drawn as siblings.
+			[]testMessage{
+				{"message-id": "<2@x>", "subject": "Re: a", "references": "<1@x>"},
+				{"message-id": "<3@x>", "subject": "Re: a", "references": "<1@x>"},
+			},
+			"Re: a\nRe: a"},
+
+		{"replies arrive first",
+			[]testMessage{
+				{"message-id": "<2@x>", "subject": "Re: a", "in-reply-to": "<1@x>"},
+				{"message-id": "<1@x>", "subject": "a"},
+			},
+			"a\n`->Re: a"},
+
+		{"subject fallback",
+			[]testMessage{
+				{"message-id": "<1@x>", "subject": "Lunch?"},
+				{"message-id": "<2@x>", "subject": "other"},
+				{"message-id": "<3@x>", "subject": "RE: lunch?"},
+				{"message-id": "<4@x>", "subject": "Fwd: Re: Lunch?"},
+			},
+			"Lunch?\n|->RE: lunch?\n`->Fwd: Re: Lunch?\nother"},
+
+		{"no message-ids",
+			[]testMessage{
+				{"subject": "one"},
+				{"subject": "two"},
+			},
+			"one\ntwo"},
+
+		{"duplicate message-ids",
+			[]testMessage{
+				{"message-id": "<1@x>", "subject": "one"},
+				{"message-id": "<1@x>", "subject": "copy"},
+			},
+			"one\ncopy"},
+
+		{"reference loop",
+			// The second reference would create a loop, so is ignored.
+			[]testMessage{
+				{"message-id": "<1@x>", "subject": "a", "references": "<2@x>"},
+				{"message-id": "<2@x>", "subject": "b", "references": "<1@x>"},
+			},
+			"b\n`->a"},
+	}
+
+	for _, tst := range tests {
+		out := render(tst.Messages)
+		if out != tst.Expected {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", tst.Name, tst.Expected, out)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+
+	messages := []Message{
+		testMessage{"message-id": "<1@x>", "subject": "a"},
+		testMessage{"message-id": "<2@x>", "subject": "b"},
+		testMessage{"message-id": "<3@x>", "subject": "c", "in-reply-to": "<1@x>"},
+	}
+
+	entries := Flatten(Thread(messages))
+	if len(entries) != 3 {
+		t.Fatalf("expected three entries, got %d", len(entries))
+	}
+
+	expected := []Entry{
+		{Index: 0, Thread: 0, Depth: 0, Count: 2},
+		{Index: 2, Thread: 0, Depth: 1, Count: 2, Tree: "`->"},
+		{Index: 1, Thread: 1, Depth: 0, Count: 1},
+	}
+	for i, e := range expected {
+		if entries[i] != e {
+			t.Errorf("entry %d: expected %+v, got %+v", i, e, entries[i])
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+
+	// Two roots share a subject, so the reply could be attached to
+	// either of them - but it must be the same one every time.
+	messages := []Message{
+		testMessage{"message-id": "<1@x>", "subject": "hello"},
+		testMessage{"message-id": "<2@x>", "subject": "hello"},
+		testMessage{"message-id": "<3@x>", "subject": "Re: hello"},
+	}
+
+	var expected []Entry
+	for i := 0; i < 200; i++ {
+
+		entries := Flatten(Thread(messages))
+		if i == 0 {
+			expected = entries
+			continue
+		}
+
+		if len(entries) != len(expected) {
+			t.Fatalf("run %d: expected %d entries, got %d", i, len(expected), len(entries))
+		}
+		for j, e := range expected {
+			if entries[j] != e {
+				t.Fatalf("run %d: entry %d: expected %+v, got %+v", i, j, e, entries[j])
+			}
+		}
+	}
+
+	// The reply belongs to the first message.
+	if expected[0].Index != 0 || expected[1].Index != 2 || expected[1].Depth != 1 {
+		t.Errorf("reply wasn't attached to the first message: %+v", expected)
+	}
+}
@@ -0,0 +1,306 @@
+// Package watcher reports changes to the messages within maildirs.
+//
+// The new/ and cur/ directories of each maildir are watched via the
+// operating-system's notification mechanism, such as inotify.  When a
+// change is seen we wait briefly for things to settle, then rescan the
+// affected maildirs and compare them against their previous contents.
+//
+// Comparing snapshots, rather than interpreting the raw notifications,
+// means that a flag-change is reported as a single event rather than a
+// deletion and a creation, and that events can't be missed if several
+// changes happen at once.
+package watcher
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/skx/maildir-tools/finder"
+)
+
+// Type describes what happened to a message.
+type Type string
+
+const (
+	// Arrive means a new message has appeared in a maildir.
+	Arrive Type = "arrive"
+
+	// Flags means the flags of a message have changed, which
+	// includes it moving from new/ to cur/.
+	Flags Type = "flags"
+
+	// Move means a message has moved from one maildir to another.
+	Move Type = "move"
+
+	// Delete means a message has been removed.
+	Delete Type = "delete"
+)
+
+// Delay is the time we wait after a change, to allow any related changes
+// to complete, before we rescan the maildirs.
+var Delay = 200 * time.Millisecond
+
+// Event describes a change to a single message.
+type Event struct {
+
+	// Type describes what happened.
+	Type Type
+
+	// Maildir holds the maildir containing the message, or which
+	// contained it if it was deleted.
+	Maildir string
+
+	// Path holds the path to the message, or where it was if it
+	// was deleted.
+	Path string
+
+	// OldMaildir and OldPath hold the previous location of the
+	// message, for moves and flag-changes.
+	OldMaildir string
+	OldPath    string
+}
+
+// Watcher watches a set of maildirs.
+type Watcher struct {
+
+	// Events receives the events which happened in each rescan.
+	//
+	// The channel is closed when the watcher is closed.
+	Events chan []Event
+
+	// Errors receives any errors from the underlying watcher.
+	Errors chan error
+
+	// watcher is our underlying watcher.
+	watcher *fsnotify.Watcher
+
+	// snapshots holds the messages in each maildir, indexed by
+	// their unique names.
+	snapshots map[string]map[string]string
+
+	// order holds the maildirs in the order they were given, so
+	// that events are reported consistently.
+	order []string
+
+	// done is closed to stop our goroutine.
+	done chan struct{}
+
+	// closed ensures we only close once.
+	closed sync.Once
+}
+
+// New creates a watcher for the given maildirs.
+func New(maildirs []string) (*Watcher, error) {
+
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		Events:    make(chan []Event),
+		Errors:    make(chan error, 1),
+		watcher:   fs,
+		snapshots: make(map[string]map[string]string),
+		done:      make(chan struct{}),
+	}
+
+	for _, maildir := range maildirs {
+
+		maildir = filepath.Clean(maildir)
+		if _, ok := w.snapshots[maildir]; ok {
+			continue
+		}
+
+		for _, sub := range []string{"new", "cur"} {
+			if err := fs.Add(filepath.Join(maildir, sub)); err != nil {
+				fs.Close()
+				return nil, err
+			}
+		}
+
+		w.snapshots[maildir] = scan(maildir)
+		w.order = append(w.order, maildir)
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Close stops watching, and closes our Events channel.
+//
+// It is safe to call Close more than once.
+func (w *Watcher) Close() error {
+
+	var err error
+	w.closed.Do(func() {
+		close(w.done)
+		err = w.watcher.Close()
+	})
+	return err
+}
+
+// run is the main-loop of our watcher.
+func (w *Watcher) run() {
+
+	defer close(w.Events)
+
+	// The maildirs which have changed since we last scanned.
+	dirty := make(map[string]bool)
+
+	// The timer which fires when we should scan, if any.
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			maildir := filepath.Dir(filepath.Dir(ev.Name))
+			if _, ok := w.snapshots[maildir]; !ok {
+				continue
+			}
+
+			dirty[maildir] = true
+			if timer == nil {
+				timer = time.After(Delay)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			// Don't block if nobody is reading errors.
+			select {
+			case w.Errors <- err:
+			default:
+			}
+
+		case <-timer:
+			timer = nil
+
+			events := w.rescan(dirty)
+			dirty = make(map[string]bool)
+
+			if len(events) == 0 {
+				continue
+			}
+
+			select {
+			case w.Events <- events:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// rescan scans the given maildirs, updating our snapshots, and returns
+// the events which explain the differences.
+func (w *Watcher) rescan(dirty map[string]bool) []Event {
+
+	var events []Event
+
+	// Messages which vanished, and appeared, in this scan, so
+	// we can pair them up as moves.
+	gone := make(map[string]Event)
+	var goneOrder []string
+	var arrived []Event
+
+	for _, maildir := range w.order {
+
+		if !dirty[maildir] {
+			continue
+		}
+
+		old := w.snapshots[maildir]
+		cur := scan(maildir)
+		w.snapshots[maildir] = cur
+
+		for _, name := range sortedKeys(cur) {
+			path := cur[name]
+
+			prev, ok := old[name]
+			if !ok {
+				arrived = append(arrived, Event{Type: Arrive, Maildir: maildir, Path: path})
+				continue
+			}
+			if prev != path {
+				events = append(events, Event{Type: Flags, Maildir: maildir, Path: path, OldMaildir: maildir, OldPath: prev})
+			}
+		}
+
+		for _, name := range sortedKeys(old) {
+			if _, ok := cur[name]; !ok {
+				gone[name] = Event{Type: Delete, Maildir: maildir, Path: old[name]}
+				goneOrder = append(goneOrder, name)
+			}
+		}
+	}
+
+	// A message which vanished from one maildir, and arrived in
+	// another, has been moved.
+	for _, ev := range arrived {
+
+		name := finder.Unique(ev.Path)
+		if del, ok := gone[name]; ok {
+			delete(gone, name)
+			ev.Type = Move
+			ev.OldMaildir = del.Maildir
+			ev.OldPath = del.Path
+		}
+		events = append(events, ev)
+	}
+
+	for _, name := range goneOrder {
+		if ev, ok := gone[name]; ok {
+			events = append(events, ev)
+		}
+	}
+
+	return events
+}
+
+// scan returns the messages in the given maildir, indexed by their unique
+// names.
+func scan(maildir string) map[string]string {
+
+	messages := make(map[string]string)
+
+	for _, sub := range []string{"new", "cur"} {
+
+		dir := filepath.Join(maildir, sub)
+
+		// A missing directory is just empty.
+		files, _ := ioutil.ReadDir(dir)
+		for _, file := range files {
+			if file.Mode().IsRegular() {
+				messages[finder.Unique(file.Name())] = filepath.Join(dir, file.Name())
+			}
+		}
+	}
+
+	return messages
+}
+
+// sortedKeys returns the keys of the given map, sorted.
+func sortedKeys(m map[string]string) []string {
+
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
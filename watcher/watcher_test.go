@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skx/maildir-tools/mailreader"
+)
+
+// makeMaildir creates an empty maildir beneath the given directory.
+func makeMaildir(t *testing.T, dir string) string {
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("failed to create maildir: %s", err)
+		}
+	}
+	return dir
+}
+
+// next returns the next batch of events from the watcher.
+func next(t *testing.T, w *Watcher) []Event {
+
+	select {
+	case events := <-w.Events:
+		return events
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for events")
+	}
+	return nil
+}
+
+func TestWatcher(t *testing.T) {
+
+	Delay = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbox := makeMaildir(t, filepath.Join(dir, "inbox"))
+	archive := makeMaildir(t, filepath.Join(dir, "archive"))
+
+	w, err := New([]string{inbox, archive})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %s", err)
+	}
+	defer w.Close()
+
+	// A message arrives.
+	path := filepath.Join(inbox, "new", "1234.host")
+	if err = ioutil.WriteFile(path, []byte("Subject: test\n\nBody\n"), 0644); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	events := next(t, w)
+	if len(events) != 1 || events[0].Type != Arrive || events[0].Path != path || events[0].Maildir != inbox {
+		t.Fatalf("unexpected events for arrival: %+v", events)
+	}
+
+	// It is read.
+	seen := filepath.Join(inbox, "cur", "1234.host:2,S")
+	if err = os.Rename(path, seen); err != nil {
+		t.Fatalf("failed to rename message: %s", err)
+	}
+
+	events = next(t, w)
+	if len(events) != 1 || events[0].Type != Flags || events[0].Path != seen || events[0].OldPath != path {
+		t.Fatalf("unexpected events for flag-change: %+v", events)
+	}
+
+	// It is archived.
+	archived := filepath.Join(archive, "cur", "1234.host:2,S")
+	if err = os.Rename(seen, archived); err != nil {
+		t.Fatalf("failed to move message: %s", err)
+	}
+
+	events = next(t, w)
+	if len(events) != 1 || events[0].Type != Move || events[0].Maildir != archive || events[0].OldMaildir != inbox {
+		t.Fatalf("unexpected events for move: %+v", events)
+	}
+
+	// It is deleted.
+	if err = os.Remove(archived); err != nil {
+		t.Fatalf("failed to remove message: %s", err)
+	}
+
+	events = next(t, w)
+	if len(events) != 1 || events[0].Type != Delete || events[0].Path != archived {
+		t.Fatalf("unexpected events for deletion: %+v", events)
+	}
+}
+
+func TestWatcherMove(t *testing.T) {
+
+	Delay = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbox := makeMaildir(t, filepath.Join(dir, "inbox"))
+	archive := makeMaildir(t, filepath.Join(dir, "archive"))
+
+	path := filepath.Join(inbox, "cur", "1234.host:2,S")
+	if err = ioutil.WriteFile(path, []byte("Subject: test\n\nBody\n"), 0644); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	w, err := New([]string{inbox, archive})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %s", err)
+	}
+	defer w.Close()
+
+	// Moving via a copy, as we do, is still seen as a move.
+	moved, err := mailreader.Move(path, archive)
+	if err != nil {
+		t.Fatalf("failed to move message: %s", err)
+	}
+
+	events := next(t, w)
+	if len(events) != 1 || events[0].Type != Move || events[0].Path != moved || events[0].OldPath != path {
+		t.Fatalf("unexpected events for move: %+v", events)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := New([]string{makeMaildir(t, dir)})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %s", err)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("unexpected error closing watcher: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("unexpected error closing watcher twice: %s", err)
+	}
+
+	// Our events channel is closed.
+	select {
+	case _, ok := <-w.Events:
+		if ok {
+			t.Fatalf("unexpected event after closing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for events to close")
+	}
+}